@@ -0,0 +1,58 @@
+package main
+
+import (
+    "fmt"
+    "time"
+)
+
+// The echo requests sent and answered within one time window
+type bucket struct {
+    trans int
+    recv int
+}
+
+// Count an echo request in the window it was sent in
+func recordBucket(s *statsData, at time.Time, ok bool) {
+    i := int(at.Sub(s.start) / opts.availability)
+    for len(s.buckets) <= i {
+        s.buckets = append(s.buckets, bucket{})
+    }
+    s.buckets[i].trans++
+    if ok {
+        s.buckets[i].recv++
+    }
+}
+
+// Add the windows of a round to those of the whole run. The windows of a
+// round start with the round, so each is counted in the window of the run
+// it starts in
+func mergeBuckets(s *statsData, round *statsData) {
+    offset := round.start.Sub(s.start)
+    for i, b := range round.buckets {
+        j := int((offset + time.Duration(i) * opts.availability) / opts.availability)
+        if j < 0 {
+            // The round started before the statistics were last reset
+            j = 0
+        }
+        for len(s.buckets) <= j {
+            s.buckets = append(s.buckets, bucket{})
+        }
+        s.buckets[j].trans += b.trans
+        s.buckets[j].recv += b.recv
+    }
+}
+
+// Print the availability in each window, windows without echo requests
+// being shown as a gap
+func availabilityStats(s *statsData) {
+    fmt.Fprintf(out, "availability per %v:\n", opts.availability)
+    for i, b := range s.buckets {
+        from := time.Duration(i) * opts.availability
+        if b.trans == 0 {
+            fmt.Fprintf(out, "  +%-10v -\n", from)
+            continue
+        }
+        fmt.Fprintf(out, "  +%-10v %5.1f%% (%v/%v)\n", from,
+            float64(b.recv) / float64(b.trans) * 100, b.recv, b.trans)
+    }
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+    "net"
+    "syscall"
+)
+
+// Bind the connection to the named interface, so packets leave through it
+// whatever the routing table would otherwise choose
+func bindToDevice(c *net.IPConn, name string) error {
+    raw, err := c.SyscallConn()
+    if err != nil {
+        return err
+    }
+    var sockErr error
+    err = raw.Control(func(fd uintptr) {
+        sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET,
+            syscall.SO_BINDTODEVICE, name)
+    })
+    if err != nil {
+        return err
+    }
+    return sockErr
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+    "fmt"
+    "net"
+)
+
+// Bind the connection to the named interface, so packets leave through it
+// whatever the routing table would otherwise choose
+func bindToDevice(c *net.IPConn, name string) error {
+    return fmt.Errorf("binding to an interface is only supported on Linux")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "net"
+    "sync"
+    "time"
+)
+
+// Ping two hosts at once for count times, forever if 0, printing their
+// round-trip times side by side and comparing them at the end
+func compare(hosts [2]string, ips [2]net.IP, dataSize int, count int, done chan bool) {
+    var s [2]statsData
+    for i := range s {
+        s[i].rtts = make([]float64, 0)
+        s[i].start = time.Now()
+    }
+
+    width := len(hosts[0])
+    if len(hosts[1]) > width {
+        width = len(hosts[1])
+    }
+    if width < 12 {
+        width = 12
+    }
+    fmt.Fprintf(out, "%8v  %*v  %*v\n", "SEQ", width, hosts[0], width, hosts[1])
+
+    for seq := 0; count == 0 || seq < count; seq++ {
+        if seq > 0 {
+            select {
+            case <-done:
+                compareStats(hosts, &s)
+                return
+            case <-time.After(opts.interval):
+            }
+        }
+
+        // Replies are matched by sequence, so each host gets its own
+        var replies [2]echoReply
+        var errs [2]error
+        var wg sync.WaitGroup
+        for i, ip := range ips {
+            wg.Add(1)
+            go func(i int, ip net.IP) {
+                defer wg.Done()
+                replies[i], errs[i] = pingOnce(seq * 2 + i, ip.String(), ip.To4() == nil,
+                    dataSize, opts.ttl)
+            }(i, ip)
+        }
+        wg.Wait()
+
+        var rtts [2]string
+        for i := range ips {
+            s[i].trans++
+            if errs[i] != nil {
+                rtts[i] = "timeout"
+                continue
+            }
+            s[i].recv++
+            s[i].rtts = append(s[i].rtts, replies[i].rtt)
+            rtts[i] = fmt.Sprintf("%.*f %v", opts.precision, inUnit(replies[i].rtt), opts.unit)
+        }
+        fmt.Fprintf(out, "%8v  %*v  %*v\n", formatSeq(seq), width, rtts[0], width, rtts[1])
+
+        select {
+        case <-done:
+            compareStats(hosts, &s)
+            return
+        default:
+        }
+    }
+    compareStats(hosts, &s)
+}
+
+// Print the statistics of both hosts and which of them is faster
+func compareStats(hosts [2]string, s *[2]statsData) {
+    fmt.Fprintln(out, "\n--- Comparison ---")
+    var avgs [2]float64
+    for i := range s {
+        _, _, avgs[i], _ = rttStats(&s[i])
+        fmt.Fprintf(out, "%v: %v transmitted, %v received, %.1f%% loss, avg %.*f %v\n",
+            hosts[i], s[i].trans, s[i].recv, lossPercent(&s[i]), opts.precision,
+            inUnit(avgs[i]), opts.unit)
+    }
+
+    if s[0].recv == 0 && s[1].recv == 0 {
+        fmt.Fprintln(out, "Neither host replied")
+        return
+    }
+    for i := range s {
+        if s[i].recv == 0 {
+            fmt.Fprintf(out, "%v never replied, only %v is up\n", hosts[i], hosts[1 - i])
+            return
+        }
+    }
+
+    faster, slower := 0, 1
+    if avgs[1] < avgs[0] {
+        faster, slower = 1, 0
+    }
+    fmt.Fprintf(out, "%v is faster by %.*f %v on average\n",
+        hosts[faster], opts.precision, inUnit(avgs[slower] - avgs[faster]), opts.unit)
+    fmt.Fprintf(out, "loss difference: %.1f%%\n",
+        math.Abs(lossPercent(&s[0]) - lossPercent(&s[1])))
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+    "net"
+    "syscall"
+)
+
+// Set the don't fragment bit on the packets sent on the connection, ignoring
+// the path MTU the kernel has learned so that larger packets are still sent
+func setDontFragment(c *net.IPConn, isIPv6 bool) error {
+    raw, err := c.SyscallConn()
+    if err != nil {
+        return err
+    }
+    var sockErr error
+    err = raw.Control(func(fd uintptr) {
+        if isIPv6 {
+            sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6,
+                syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+        } else {
+            sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP,
+                syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+        }
+    })
+    if err != nil {
+        return err
+    }
+    return sockErr
+}
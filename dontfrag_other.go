@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+    "fmt"
+    "net"
+)
+
+// Set the don't fragment bit on the packets sent on the connection, ignoring
+// the path MTU the kernel has learned so that larger packets are still sent
+func setDontFragment(c *net.IPConn, isIPv6 bool) error {
+    return fmt.Errorf("setting don't fragment is only supported on Linux")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net"
+    "sync"
+    "time"
+)
+
+// How long writing an event may block before it is dropped
+const eventWriteTimeout = 100 * time.Millisecond
+
+// A Unix domain socket each probe result is written to as a JSON line, for
+// a local agent to consume. Events are dropped while nothing listens on it
+type eventSocket struct {
+    mu sync.Mutex
+    path string
+    conn net.Conn
+    down bool
+}
+
+// The socket given by --event-socket, if any
+var events *eventSocket
+
+// Write an event to the socket, connecting first if not connected. Losing
+// the listener is reported once, and connecting is retried with every event
+// until it is back
+func (e *eventSocket) send(event interface{}) {
+    data, err := json.Marshal(event)
+    if err != nil {
+        fmt.Fprintln(errOut, "Error: Failed to marshal event", err)
+        return
+    }
+
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    if e.conn == nil {
+        e.conn, err = net.DialTimeout("unix", e.path, eventWriteTimeout)
+        if err != nil {
+            e.conn = nil
+            e.lost(err)
+            return
+        }
+    }
+
+    e.conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+    _, err = e.conn.Write(append(data, '\n'))
+    if err != nil {
+        e.conn.Close()
+        e.conn = nil
+        e.lost(err)
+        return
+    }
+    if e.down {
+        fmt.Fprintln(errOut, "Event socket", e.path, "is back")
+        e.down = false
+    }
+}
+
+// Warn that events are being dropped, unless already warned
+func (e *eventSocket) lost(err error) {
+    if !e.down {
+        fmt.Fprintf(errOut, "Warning: dropping events until %v accepts them: %v\n",
+            e.path, err)
+        e.down = true
+    }
+}
+
+func (e *eventSocket) Close() error {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    if e.conn == nil {
+        return nil
+    }
+    return e.conn.Close()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+    "net"
+    "syscall"
+)
+
+// Add an empty IPv6 hop-by-hop ("hbh") or destination ("dst") options
+// extension header to the packets sent on the connection
+func setExtHeader(c *net.IPConn, kind string) error {
+    opt := syscall.IPV6_HOPOPTS
+    if kind == "dst" {
+        opt = syscall.IPV6_DSTOPTS
+    }
+
+    // The kernel fills in the next header; a PadN option fills the 8 bytes
+    header := []byte{0, 0, 1, 4, 0, 0, 0, 0}
+
+    raw, err := c.SyscallConn()
+    if err != nil {
+        return err
+    }
+    var sockErr error
+    err = raw.Control(func(fd uintptr) {
+        sockErr = syscall.SetsockoptString(int(fd), syscall.IPPROTO_IPV6, opt,
+            string(header))
+    })
+    if err != nil {
+        return err
+    }
+    return sockErr
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+    "fmt"
+    "net"
+)
+
+// Add an empty IPv6 hop-by-hop ("hbh") or destination ("dst") options
+// extension header to the packets sent on the connection
+func setExtHeader(c *net.IPConn, kind string) error {
+    return fmt.Errorf("extension headers are only supported on Linux")
+}
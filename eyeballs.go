@@ -0,0 +1,72 @@
+package main
+
+import (
+    "fmt"
+    "net"
+)
+
+// How many addresses of each family to race
+const raceAddressesPerFamily = 2
+
+type raceResult struct {
+    ip net.IP
+    reply echoReply
+    err error
+}
+
+// Ping the first addresses of each family at once, like Happy Eyeballs
+// (RFC 8305) clients racing connections, and report which replies fastest
+func raceAddresses(host string, ips []net.IP, dataSize int) {
+    candidates := make([]net.IP, 0)
+    v4, v6 := 0, 0
+    for _, ip := range ips {
+        if ip.To4() != nil && v4 < raceAddressesPerFamily {
+            candidates = append(candidates, ip)
+            v4++
+        } else if ip.To4() == nil && v6 < raceAddressesPerFamily {
+            candidates = append(candidates, ip)
+            v6++
+        }
+    }
+
+    fmt.Fprintf(out, "PING %v: racing %v IPv4 and %v IPv6 addresses, %v data bytes\n",
+        host, v4, v6, dataSize)
+
+    // Distinct sequence numbers keep the replies of the probes apart
+    results := make(chan raceResult)
+    for i, ip := range candidates {
+        go func(seq int, ip net.IP) {
+            reply, err := pingOnce(seq, ip.String(), ip.To4() == nil, dataSize, opts.ttl)
+            results <- raceResult{ip, reply, err}
+        }(i, ip)
+    }
+
+    var winner *raceResult
+    for range candidates {
+        r := <-results
+        if r.err != nil {
+            fmt.Fprintf(errOut, "Request timeout for %v (%v)\n", r.ip, familyName(r.ip))
+            continue
+        }
+        fmt.Fprintf(out, "Packet from %v (%v): time=%.*f %v\n",
+            displayAddr(r.ip.String()), familyName(r.ip), opts.precision, inUnit(r.reply.rtt), opts.unit)
+        if winner == nil || r.reply.rtt < winner.reply.rtt {
+            winner = &r
+        }
+    }
+
+    if winner == nil {
+        fmt.Fprintln(out, "\nNo address replied")
+        return
+    }
+    fmt.Fprintf(out, "\nFastest: %v (%v) time=%.*f %v\n",
+        winner.ip, familyName(winner.ip), opts.precision, inUnit(winner.reply.rtt), opts.unit)
+}
+
+// Name the family of the address
+func familyName(ip net.IP) string {
+    if ip.To4() == nil {
+        return "IPv6"
+    }
+    return "IPv4"
+}
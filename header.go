@@ -0,0 +1,53 @@
+package main
+
+import (
+    "fmt"
+    "math/rand"
+    "net"
+    "strconv"
+
+    "golang.org/x/net/ipv4"
+)
+
+// Parse the IPv4 identification given by --ip-id, a number from 1 to 65535
+// or "random" for a new random one on every packet, which -1 stands for
+func parseIPID(arg string) (id int, err error) {
+    if arg == "random" {
+        return -1, nil
+    }
+    id, err = strconv.Atoi(arg)
+    if err != nil || id < 1 || id > 0xffff {
+        err = fmt.Errorf("ip-id must be a number from 1 to 65535 or random")
+    }
+    return
+}
+
+// Prepend an IPv4 header of our own to an echo message, for a socket that
+// sends its own headers. The kernel fills in what is left zero: the source
+// unless spoofed, the ID unless given by --ip-id, and the checksum
+func ipv4Packet(msg []byte, dst net.IP) ([]byte, error) {
+    ttl := opts.ttl
+    if ttl == 0 {
+        ttl = 64
+    }
+    id := opts.ipID
+    if id < 0 {
+        id = 1 + rand.Intn(0xffff)
+    }
+    header := ipv4.Header{
+        Version: ipv4.Version,
+        Len: ipv4.HeaderLen,
+        TOS: opts.tos,
+        TotalLen: ipv4.HeaderLen + len(msg),
+        ID: id,
+        TTL: ttl,
+        Protocol: 1,
+        Src: opts.spoofSource,
+        Dst: dst,
+    }
+    b, err := header.Marshal()
+    if err != nil {
+        return nil, err
+    }
+    return append(b, msg...), nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+    "net"
+    "syscall"
+)
+
+// Set the IPv4 options of the packets sent on the connection
+func setIPOptions(c *net.IPConn, options []byte) error {
+    raw, err := c.SyscallConn()
+    if err != nil {
+        return err
+    }
+    var sockErr error
+    err = raw.Control(func(fd uintptr) {
+        sockErr = syscall.SetsockoptString(int(fd), syscall.IPPROTO_IP,
+            syscall.IP_OPTIONS, string(options))
+    })
+    if err != nil {
+        return err
+    }
+    return sockErr
+}
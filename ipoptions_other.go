@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+    "fmt"
+    "net"
+)
+
+// Set the IPv4 options of the packets sent on the connection
+func setIPOptions(c *net.IPConn, options []byte) error {
+    return fmt.Errorf("IP options are only supported on Linux")
+}
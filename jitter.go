@@ -0,0 +1,45 @@
+package main
+
+import (
+    "fmt"
+    "time"
+)
+
+// How many of the latest round-trip times the jitter is measured over
+const jitterWindow = 10
+
+// Latency becomes unstable when the standard deviation over the window exceeds
+// this fraction of its mean, and stable again once it falls below half of that
+const jitterRatio = 0.5
+
+// The least time between two jitter warnings
+const jitterWarnGap = time.Minute
+
+// Check the latest round-trip times for the onset of jitter, warning when
+// latency turns unstable unless a warning was given too recently
+func watchJitter(s *statsData, seq int) {
+    if len(s.rtts) < jitterWindow {
+        return
+    }
+    window := s.rtts[len(s.rtts) - jitterWindow:]
+    _, _, rttAvg, rttStd := rttStats(&statsData{recv: len(window), rtts: window})
+
+    if s.unstable {
+        if rttStd < rttAvg * jitterRatio / 2 {
+            s.unstable = false
+        }
+        return
+    }
+    if rttStd <= rttAvg * jitterRatio {
+        return
+    }
+
+    s.unstable = true
+    if time.Since(s.jitterWarned) >= jitterWarnGap {
+        s.jitterWarned = time.Now()
+        fmt.Fprintf(errOut, "Warning: latency became unstable at icmp_seq=%v " +
+            "(std-dev %.*f %v over the last %v replies, avg %.*f %v)\n",
+            formatSeq(seq), opts.precision, inUnit(rttStd), opts.unit, jitterWindow,
+            opts.precision, inUnit(rttAvg), opts.unit)
+    }
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+)
+
+// Statistics in JSON form
+type summaryJSON struct {
+    Host string `json:"host"`
+    IP string `json:"ip"`
+    Transmitted int `json:"transmitted"`
+    Received int `json:"received"`
+    Corrupted int `json:"corrupted"`
+    BadChecksums int `json:"bad_checksums"`
+    BadCodes int `json:"bad_codes"`
+    Loss float64 `json:"loss"`
+    RTTMin float64 `json:"rtt_min"`
+    RTTAvg float64 `json:"rtt_avg"`
+    RTTMax float64 `json:"rtt_max"`
+    RTTStdDev float64 `json:"rtt_stddev"`
+    SLAPercent *float64 `json:"sla_percent,omitempty"`
+}
+
+// Summarize statistics for JSON output
+func jsonSummary(s *statsData, host string, ip string) summaryJSON {
+    rttMin, rttMax, rttAvg, rttStd := rttStats(s)
+    var sla *float64
+    if opts.slaRTT > 0 {
+        percent := slaPercent(s)
+        sla = &percent
+    }
+    return summaryJSON{
+        Host: host,
+        IP: ip,
+        Transmitted: s.trans,
+        Received: s.recv,
+        Corrupted: s.corrupted,
+        BadChecksums: s.badChecksums,
+        BadCodes: s.badCodes,
+        Loss: lossPercent(s),
+        RTTMin: rttMin,
+        RTTAvg: rttAvg,
+        RTTMax: rttMax,
+        RTTStdDev: rttStd,
+        SLAPercent: sla,
+    }
+}
+
+// Print statistics as a JSON object on a single line
+func jsonStats(s *statsData, host string, ip string) {
+    data, err := json.Marshal(jsonSummary(s, host, ip))
+    if err != nil {
+        fmt.Fprintln(errOut, "Error: Failed to marshal summary", err)
+        return
+    }
+    fmt.Fprintln(out, string(data))
+}
+
+// The version of the NDJSON event schema, raised on incompatible changes
+const ndjsonVersion = 1
+
+// The fields every NDJSON event starts with
+type eventJSON struct {
+    Version int `json:"version"`
+    Type string `json:"type"`
+}
+
+type replyEventJSON struct {
+    eventJSON
+    From string `json:"from"`
+    Seq int `json:"seq"`
+    TTL int `json:"ttl"`
+    Bytes int `json:"bytes"`
+    RTT float64 `json:"rtt"`
+    Corrupted bool `json:"corrupted"`
+    BadChecksum bool `json:"bad_checksum"`
+    Code int `json:"code"`
+}
+
+type failureEventJSON struct {
+    eventJSON
+    Seq int `json:"seq"`
+    From string `json:"from,omitempty"`
+    Error string `json:"error,omitempty"`
+}
+
+type usageErrorEventJSON struct {
+    eventJSON
+    Error string `json:"error"`
+}
+
+type summaryEventJSON struct {
+    eventJSON
+    summaryJSON
+}
+
+// Print an NDJSON event as a compact JSON object on its own line
+func emit(event interface{}) {
+    data, err := json.Marshal(event)
+    if err != nil {
+        fmt.Fprintln(errOut, "Error: Failed to marshal event", err)
+        return
+    }
+    fmt.Fprintln(out, string(data))
+}
+
+// Print a reply event
+func emitReply(seq int, reply echoReply) {
+    emit(replyEvent(seq, reply))
+}
+
+// Print a timeout event, or an error event for any other failure
+func emitFailure(seq int, reply echoReply, err error) {
+    emit(failureEvent(seq, reply, err))
+}
+
+// Build the event for a reply
+func replyEvent(seq int, reply echoReply) replyEventJSON {
+    return replyEventJSON{
+        eventJSON: eventJSON{ndjsonVersion, "reply"},
+        From: reply.from.String(),
+        Seq: seq,
+        TTL: reply.ttl,
+        Bytes: replySize(reply),
+        RTT: reply.rtt,
+        Corrupted: reply.corrupted,
+        BadChecksum: reply.badChecksum,
+        Code: reply.code,
+    }
+}
+
+// Build a timeout event, or an error event for any other failure
+func failureEvent(seq int, reply echoReply, err error) failureEventJSON {
+    if errors.Is(err, os.ErrDeadlineExceeded) {
+        return failureEventJSON{eventJSON: eventJSON{ndjsonVersion, "timeout"}, Seq: seq}
+    }
+
+    event := failureEventJSON{
+        eventJSON: eventJSON{ndjsonVersion, "error"},
+        Seq: seq,
+        Error: err.Error(),
+    }
+    if reply.from != nil {
+        event.From = reply.from.String()
+    }
+    return event
+}
+
+// Print an event for arguments that were wrong, so nothing was pinged
+func emitUsageError(msg string) {
+    emit(usageErrorEventJSON{eventJSON{ndjsonVersion, "usage_error"}, msg})
+}
+
+// Print a summary event
+func ndjsonStats(s *statsData, host string, ip string) {
+    emit(summaryEventJSON{eventJSON{ndjsonVersion, "summary"}, jsonSummary(s, host, ip)})
+}
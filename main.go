@@ -1,14 +1,25 @@
 package main
 
 import (
+   "bytes"
+   "context"
+   "errors"
    "fmt"
    "strings"
    "time"
    "math"
+   "math/rand"
+   crand "crypto/rand"
    "encoding/binary"
+   "encoding/hex"
    "flag"
+   "io"
    "os"
    "os/signal"
+   "runtime"
+   "sort"
+   "strconv"
+   "sync"
    "syscall"
    "golang.org/x/net/icmp"
    "golang.org/x/net/ipv4"
@@ -16,46 +27,530 @@ import (
    "net"
 )
 
+// The echo payload starts with the send timestamp followed by the sequence
+// number, so replies can be matched even if the ICMP sequence is rewritten
+const (
+    timestampLen = 8
+    payloadHeaderLen = timestampLen + 4
+)
+
+// With --nonce, a random nonce follows, which a reply must echo exactly
+const nonceLen = 8
+
+// The rest of the payload is filled with padding
+const padding = " "
+
+// The most payload bytes printed by --dump
+const maxDumpLen = 128
+
+// The shortest interval allowed without --allow-flood
+const minInterval = 200 * time.Millisecond
+
+// The first pause before retrying a temporary DNS failure, and how long to
+// keep retrying
+const (
+    resolveBackoff = 100 * time.Millisecond
+    maxResolveRetry = 5 * time.Second
+)
+
+// Exit status when the host cannot be resolved in time
+const exitResolveTimeout = 3
+
+// Exit status when --stop-on-loss stopped at a lost echo request
+const exitPacketLoss = 1
+
+// Exit status when too few replies were within --sla-rtt
+const exitSLAMissed = 4
+
+// Exit status when the arguments are wrong, as when a flag fails to parse
+const exitUsage = 2
+
+// Exit status when ping cannot run, as when the host is unknown or a file
+// cannot be opened
+const exitFailure = 5
+
+var errResolveTimeout = errors.New("DNS resolution timed out")
+
+var errTimeExceeded = errors.New("time to live exceeded")
+
+var errUnreachable = errors.New("destination unreachable")
+
+var errNetUnreachable = errors.New("network is unreachable")
+
+var errTooBig = errors.New("packet too big")
+
+// The code of an ICMPv4 destination unreachable for a packet that needed
+// fragmenting but had the don't fragment bit set
+const fragmentationNeeded = 4
+
+// Why an echo request got no reply
+const (
+    causeTimeout = iota
+    causeUnreachable
+    causeTimeExceeded
+    numCauses
+)
+
+var causeNames = [numCauses]string{"timed out", "unreachable", "TTL exceeded"}
+
+// The identifier of our echo requests
+var echoID = os.Getpid() & 0xffff
+
+// Neighbor caches forget an idle peer in about this time, so the first echo
+// request after such a gap waits for ARP or neighbor discovery again
+const coldGap = 30 * time.Second
+
+// The longest interval --interval-adaptive-on-loss backs off to
+const maxBackoffInterval = time.Minute
+
+// How many times to retry sending when out of buffer space
+const writeRetries = 2
+
+// Large enough for any ICMP message
+const maxPacketSize = 65536
+
+// Options given on the command line
+type options struct {
+    precision int
+    source net.IP
+    tos int
+    interval time.Duration
+    timeout time.Duration
+    dump bool
+    stopAfter int
+    seqHex bool
+    ttl int
+    extHeader string
+    icmpType int
+    icmpCode int
+    tsOption bool
+    validateChecksum bool
+    ndjson bool
+    rotateSources []net.IP
+    randomFill []byte
+    deadlineGrace time.Duration
+    showGaps bool
+    watchJitter bool
+    device string
+    slaRTT float64
+    burst int
+    failureCauses bool
+    mark int
+    table bool
+    stopOnLoss bool
+    availability time.Duration
+    inlineStats int
+    srtt bool
+    total int
+    adaptiveInterval bool
+    spoofSource net.IP
+    ipID int
+    headerInclude bool
+    excludeCold bool
+    family int
+    selectAddr string
+    selectRand *rand.Rand
+    payload []byte
+    reverseDNS bool
+    reverseTimeout time.Duration
+    showIfindex bool
+    seqOrder []int
+    countIPHeader bool
+    trimmedMean float64
+    nonce bool
+    unit string
+    upAfter int
+    dontFragment bool
+}
+
+var opts options
+
+// A duration flag taking either a number of seconds or a duration with a
+// unit suffix, e.g. 0.5 or 500ms
+type durationValue time.Duration
+
+func (d *durationValue) String() string {
+    return time.Duration(*d).String()
+}
+
+func (d *durationValue) Set(s string) error {
+    seconds, err := strconv.ParseFloat(s, 64)
+    if err == nil {
+        *d = durationValue(seconds * float64(time.Second))
+        return nil
+    }
+
+    v, err := time.ParseDuration(s)
+    if err != nil {
+        return fmt.Errorf("invalid duration %q", s)
+    }
+    *d = durationValue(v)
+    return nil
+}
+
+// Where the ping results are written, and where errors and diagnostics are
+// written apart from them so that pipelines only see results
+var out io.Writer = os.Stdout
+var errOut io.Writer = os.Stderr
+
+// A reply to an echo request, or the ICMP error sent instead
+type echoReply struct {
+    from net.IP
+    ttl int
+    rtt float64
+    payload []byte
+    corrupted bool
+    otherSource bool
+    options []byte
+    badChecksum bool
+    ifIndex int
+    ipID int
+    cold bool
+    idRewritten bool
+    sent time.Time
+    overtakenBy []int
+    reordered bool
+    size int
+    headerLen int
+    code int
+}
+
 type statsData struct {
+    mu sync.Mutex
     trans int
     recv int
+    corrupted int
+    badChecksums int
     rtts []float64
+    lossRun int
+    longestLoss int
+    lossEvents int
+    start time.Time
+    bySource map[string]*statsData
+    lost []int
+    unstable bool
+    jitterWarned time.Time
+    causes [numCauses]int
+    causeWait [numCauses]float64
+    buckets []bucket
+    srtt float64
+    rttvar float64
+    srttSet bool
+    backedOff bool
+    lastSent time.Time
+    coldReplies int
+    netDown bool
+    firstReply time.Duration
+    reordered int
+    successRun int
+    badCodes int
+    round *statsData
+    earlierRecv int
+}
+
+// A host has no address of the family forced by -4 or -6
+type familyError string
+
+func (e familyError) Error() string {
+    return string(e)
+}
+
+// Keep the addresses of the family forced by -4 or -6, if any
+func ofFamily(ips []net.IP) (kept []net.IP) {
+    for _, ip := range ips {
+        if opts.family == 0 || (ip.To4() != nil) == (opts.family == 4) {
+            kept = append(kept, ip)
+        }
+    }
+    return
+}
+
+// Guards the source of random picks, as --serve resolves hosts for several
+// requests at once
+var selectMu sync.Mutex
+
+// Pick the address given by --select out of those the host resolved to
+func selectAddress(ips []net.IP) net.IP {
+    switch opts.selectAddr {
+    case "last":
+        return ips[len(ips)-1]
+    case "random":
+        selectMu.Lock()
+        defer selectMu.Unlock()
+        return ips[opts.selectRand.Intn(len(ips))]
+    }
+    return ips[0]
+}
+
+// Check which address --select picks, getting the source of random picks
+// seeded with seed, or with the time if it is 0
+func parseSelect(which string, seed int64) (r *rand.Rand, err error) {
+    switch which {
+    case "first", "last":
+    case "random":
+        if seed == 0 {
+            seed = time.Now().UnixNano()
+        }
+        r = rand.New(rand.NewSource(seed))
+    case "all":
+        err = fmt.Errorf("select all needs pinging several targets, " +
+            "which is not supported")
+    default:
+        err = fmt.Errorf("select must be first, last or random")
+    }
+    return
 }
 
-// Resolve the given host to get the IP address
-func resolve(host string) (ip net.IP, isIPv6 bool) {
+// Resolve the given host to get the IP address, along with all the addresses
+// it has, giving up after the timeout if it is positive
+func resolve(host string, timeout time.Duration) (ip net.IP, all []net.IP, isIPv6 bool,
+    err error) {
     isIPv6 = false
     ip = net.ParseIP(host)
 
     if ip == nil {
-       ips, err := net.LookupIP(host)
-       if err != nil {
+       ips, lookupErr := lookup(host, timeout)
+       if lookupErr != nil {
+          err = lookupErr
+          return
+      }
+      all = ips
+      ips = ofFamily(ips)
+      if len(ips) == 0 {
+          record := "A"
+          if opts.family == 6 {
+              record = "AAAA"
+          }
+          err = familyError(fmt.Sprintf("-%v requested but %v has no %v record",
+              opts.family, host, record))
           return
-      } else {
-          ip = ips[0]
       }
-    } 
+      ip = selectAddress(ips)
+    } else if all = []net.IP{ip}; len(ofFamily(all)) == 0 {
+        err = familyError(fmt.Sprintf("-%v requested but %v is not an IPv%v address",
+            opts.family, host, opts.family))
+        return
+    }
 
     if ip.To4() == nil {
         isIPv6 = true
     }
 
+    if isLocal(ip) {
+        fmt.Fprintf(errOut, "Note: %v is a local address, round-trip times will be near zero\n", ip)
+    }
+
+    return
+}
+
+// Print all addresses of the host, marking the one pinged and those left
+// out by -4 or -6
+func showAddresses(host string, all []net.IP, ip net.IP) {
+    fmt.Fprintf(errOut, "Addresses of %v:\n", host)
+    for _, addr := range all {
+        var note string
+        if addr.Equal(ip) {
+            note = fmt.Sprintf(" (selected by --select %v)", opts.selectAddr)
+        } else if len(ofFamily([]net.IP{addr})) == 0 {
+            note = fmt.Sprintf(" (left out by -%v)", opts.family)
+        }
+        fmt.Fprintf(errOut, "  %v%v\n", addr, note)
+    }
+}
+
+// The resolver hosts are looked up with, the system one unless --dns-server
+// is given
+var resolver = net.DefaultResolver
+
+// Make a resolver querying the DNS server at an address given as ip:port, or
+// as a bare ip for port 53
+func serverResolver(server string) (*net.Resolver, error) {
+    if net.ParseIP(server) != nil {
+        server = net.JoinHostPort(server, "53")
+    }
+    host, port, err := net.SplitHostPort(server)
+    if err != nil || net.ParseIP(host) == nil {
+        return nil, fmt.Errorf("DNS server must be given as ip:port")
+    }
+    if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+        return nil, fmt.Errorf("invalid DNS server port %q", port)
+    }
+
+    return &net.Resolver{
+        PreferGo: true,
+        Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+            var d net.Dialer
+            return d.DialContext(ctx, network, server)
+        },
+    }, nil
+}
+
+// How hosts are looked up, which tests replace with a fake resolver
+var lookup = lookupHost
+
+// Look up all addresses of the host, giving up after the timeout if it is
+// positive
+func lookupHost(host string, timeout time.Duration) (ips []net.IP, err error) {
+    ctx := context.Background()
+    if timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+        defer cancel()
+    }
+
+    // Retry temporary failures such as SERVFAIL with a backoff, but give up
+    // on a missing host straight away
+    var addrs []net.IPAddr
+    backoff := resolveBackoff
+    start := time.Now()
+retry:
+    for {
+        addrs, err = resolver.LookupIPAddr(ctx, host)
+        var dnsErr *net.DNSError
+        if err == nil || !errors.As(err, &dnsErr) || !dnsErr.IsTemporary ||
+            dnsErr.IsNotFound || time.Since(start) + backoff > maxResolveRetry {
+            break
+        }
+
+        select {
+        case <-ctx.Done():
+            break retry
+        case <-time.After(backoff):
+        }
+        backoff *= 2
+    }
+
+    if err != nil && ctx.Err() == context.DeadlineExceeded {
+        err = errResolveTimeout
+        return
+    } else if err != nil {
+        return
+    }
+
+    for _, addr := range addrs {
+        ips = append(ips, addr.IP)
+    }
+    return
+}
+
+// Check if the IP address is a loopback address or belongs to this machine
+func isLocal(ip net.IP) bool {
+    if ip.IsLoopback() {
+        return true
+    }
+
+    addrs, err := net.InterfaceAddrs()
+    if err != nil {
+        return false
+    }
+
+    for _, addr := range addrs {
+        if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// Get the source address from an address or interface name given by -I,
+// which must be of the same family as the target
+func sourceAddr(source string, isIPv6 bool) (ip net.IP, err error) {
+    ip = net.ParseIP(source)
+    if ip != nil {
+        if ip.To4() != nil && isIPv6 {
+            err = fmt.Errorf("source address is IPv4 but target is IPv6")
+        } else if ip.To4() == nil && !isIPv6 {
+            err = fmt.Errorf("source address is IPv6 but target is IPv4")
+        }
+        return
+    }
+
+    iface, err := net.InterfaceByName(source)
+    if err != nil {
+        err = fmt.Errorf("unknown source address or interface %v", source)
+        return
+    }
+
+    addrs, err := iface.Addrs()
+    if err != nil {
+        return
+    }
+
+    for _, addr := range addrs {
+        ipNet, ok := addr.(*net.IPNet)
+        if ok && (ipNet.IP.To4() == nil) == isIPv6 {
+            ip = ipNet.IP
+            return
+        }
+    }
+
+    family := "IPv4"
+    if isIPv6 {
+        family = "IPv6"
+    }
+    err = fmt.Errorf("interface %v has no %v address but target is %v",
+        source, family, family)
     return
 }
 
+// Check that the payload size given by -s fits in an echo request, leaving
+// room for what the payload carries unless it is from --payload-file
+func checkSize(size int) error {
+    if opts.payload != nil {
+        if size < 0 || size > maxDataSize {
+            return fmt.Errorf("size must be between 0 and %v data bytes", maxDataSize)
+        }
+        return nil
+    }
+
+    // The payload carries the send time and sequence that replies are timed
+    // and matched by
+    if size < paddingStart() || size > maxDataSize {
+        carried := "send time and sequence"
+        if opts.nonce {
+            carried = "send time, sequence and nonce"
+        }
+        return fmt.Errorf("size must be between %v and %v data bytes, " +
+            "the first %v carry the %v",
+            paddingStart(), maxDataSize, paddingStart(), carried)
+    }
+    return nil
+}
+
 // Compose an echo message
 func echo(seq int, isIPv6 bool, dataSize int) (data []byte, err error) {
-    now := time.Now().UnixNano()
-    timestamp := make([]byte, 8)
-    binary.LittleEndian.PutUint64(timestamp, uint64(now))
-    padding := []byte(strings.Repeat(" ", dataSize - 8))
+    minSize := paddingStart()
+    if opts.payload != nil {
+        minSize = 0
+    }
+    if dataSize < minSize || dataSize > maxDataSize {
+        err = fmt.Errorf("payload of %v bytes is outside %v to %v bytes",
+            dataSize, minSize, maxDataSize)
+        return
+    }
+
+    var payload []byte
+    if opts.payload != nil {
+        payload = filePayload(dataSize)
+    } else {
+        now := time.Now().UnixNano()
+        payload = make([]byte, paddingStart())
+        binary.LittleEndian.PutUint64(payload, uint64(now))
+        binary.LittleEndian.PutUint32(payload[timestampLen:], uint32(seq))
+        if opts.nonce {
+            if _, err = crand.Read(payload[payloadHeaderLen:]); err != nil {
+                return
+            }
+        }
+        payload = append(payload, fill(dataSize - paddingStart())...)
+    }
 
     msg := icmp.Message{
-        Code: 0,
+        Code: opts.icmpCode,
         Body: &icmp.Echo{
-            ID: os.Getpid() & 0xffff,
-            Seq: seq,
-            Data: append(timestamp, padding...),
+            ID: echoID,
+            Seq: wireSeq(seq),
+            Data: payload,
         },
     }
 
@@ -65,233 +560,2048 @@ func echo(seq int, isIPv6 bool, dataSize int) (data []byte, err error) {
         msg.Type = ipv4.ICMPTypeEcho
     }
 
-    data, err = msg.Marshal(nil)
-    if err != nil {
-        fmt.Println("Error: Failed to marshal echo")
+    if opts.icmpType >= 0 {
+        if isIPv6 {
+            msg.Type = ipv6.ICMPType(opts.icmpType)
+        } else {
+            msg.Type = ipv4.ICMPType(opts.icmpType)
+        }
     }
 
+    data, err = msg.Marshal(nil)
     return
 }
 
-// Ping for specified times until receiving interrupt signal
+// Ping for specified times until receiving interrupt signal. The deadline
+// given by -w also closes done, so whichever of the count and the deadline
+// is reached first stops pinging
 func pingForTimes(ip string, isIPv6 bool, dataSize int, count int, s *statsData,
     done chan bool) {
-    for i := 0; i < count; i++ {
-        ttl, rtt, err := pingOnce(i, ip, isIPv6, dataSize)
-        if err != nil {
-            fmt.Println("Request timeout for icmp_seq", i)
-        } else {
-            s.recv++
-            s.rtts = append(s.rtts, rtt)
-            var param string
-            if isIPv6 {
-                param = "hlim"
-            } else {
-                param = "ttl"
-            }
-            fmt.Printf("Packet from %v: icmp_seq=%v %v=%v time=%v ms\n",
-                ip, i, param, ttl, rtt)
+    for i := 0; i < count; i += opts.burst {
+        n := opts.burst
+        if i + n > count {
+            n = count - i
         }
-        s.trans++
-        time.Sleep(time.Second)
 
-        select {
-        case <-done:
+        // Nothing is sent after the last echo request, so its reply can be
+        // given longer to arrive
+        if i + n == count && opts.deadlineGrace > 0 {
+            timeout := opts.timeout
+            opts.timeout += opts.deadlineGrace
+            defer func() {
+                opts.timeout = timeout
+            }()
+        }
+        if !pingStep(i, n, ip, isIPv6, dataSize, s, done) {
             return
-        default:
         }
     }
-    return 
 }
 
 // Ping forever until receiving interrupt signal
 func pingForever(ip string, isIPv6 bool, dataSize int, s *statsData, done chan bool) {
-    for i := 0; ; i++ {
-        ttl, rtt, err := pingOnce(i, ip, isIPv6, dataSize)
-        if err != nil {
-            fmt.Println("Request timeout for icmp_seq", i)
-        } else {
-            s.recv++
-            s.rtts = append(s.rtts, rtt)
-            var param string
-            if isIPv6 {
-                param = "hlim"
-            } else {
-                param = "ttl"
-            }
-            fmt.Printf("Packet from %v: icmp_seq=%v %v=%v time=%v ms\n",
-                ip, i, param, ttl, rtt)
-        }
-        s.trans++
-        time.Sleep(time.Second)
-
-        select {
-        case <-done:
+    for i := 0; ; i += opts.burst {
+        if !pingStep(i, opts.burst, ip, isIPv6, dataSize, s, done) {
             return
-        default:
         }
     }
-    return 
 }
 
-// Send an ICMP echo request, wait for the reply
-func pingOnce(seq int, ip string, isIPv6 bool, dataSize int) (ttl int, rtt float64, err error) {
-    ttl = 0
-    rtt = 0.0
-    err = nil
-    timeout := time.Second
-    var c net.Conn
-
-    if isIPv6 {
-        c, err = net.Dial("ip6:ipv6-icmp", ip)
-    } else {
-        c, err = net.Dial("ip4:icmp", ip)
+// Wait for the interval after the previous echo requests, then send a burst
+// of n back to back, usually just one, and record the results, reporting
+// whether to carry on pinging
+func pingStep(seq int, n int, ip string, isIPv6 bool, dataSize int, s *statsData,
+    done chan bool) bool {
+    if seq > 0 {
+        interval := opts.interval
+        if opts.adaptiveInterval {
+            interval = backoffInterval(s)
+        }
+        select {
+        case <-done:
+            return false
+        case <-time.After(interval):
+        }
     }
 
-    if err != nil {
-        fmt.Println("Error: Failed to dial destination", err)
-        return 
+    // Nothing is sent while paused, so a pause counts as no loss
+    if !paused.wait(done) {
+        return false
     }
-    defer c.Close()
 
-    echoMsg, err := echo(seq, isIPv6, dataSize)
-    size, err := c.Write(echoMsg)
-    if err != nil {
-        return
+    if len(opts.rotateSources) > 0 {
+        opts.source = opts.rotateSources[seq / opts.burst % len(opts.rotateSources)]
     }
 
-    var data []byte
-    if isIPv6 {
-        data = make([]byte, size)
-    } else {
-        data = make([]byte, size + 20)
+    // Every echo request gets a socket of its own, which sees all replies, so
+    // sending them at once still matches each reply to its sequence
+    sent := time.Now()
+    replies := make([]echoReply, n)
+    errs := make([]error, n)
+    var wg sync.WaitGroup
+    for k := 0; k < n; k++ {
+        wg.Add(1)
+        go func(k int) {
+            defer wg.Done()
+            replies[k], errs[k] = pingOnce(seq + k, ip, isIPv6, dataSize, opts.ttl)
+        }(k)
     }
-    
-    startTime := time.Now()
-    c.SetReadDeadline(startTime.Add(timeout))
+    wg.Wait()
 
-    for time.Now().Sub(startTime) < timeout {
-        _, err = c.Read(data)
-        if err != nil {
-            return
-        }
+    // A snapshot may read the statistics at any time
+    s.mu.Lock()
+    defer s.mu.Unlock()
 
-        var replyMsg *icmp.Message
-        if isIPv6 {
-            var header *ipv6.Header
-            header, err = ipv6.ParseHeader(data)
-            if err != nil {
-                return
-            }
-            ttl = header.HopLimit
-            replyMsg, err = icmp.ParseMessage(58, data)  
-            if err != nil {
-                return
-            }
+    // The first echo request of a run or after a long gap may be slowed by
+    // address resolution, but not the rest of its burst
+    replies[0].cold = s.lastSent.IsZero() || sent.Sub(s.lastSent) > coldGap
+    s.lastSent = sent
 
-        } else {
-            var header *ipv4.Header
-            header, err = icmp.ParseIPv4Header(data)
-            if err != nil {
-                return
+    // Echo requests are sent one at a time but for bursts, so only replies
+    // within a burst can arrive out of order. A reply is reordered if the
+    // reply to a later echo request arrived before it
+    for k := 0; k < n; k++ {
+        for _, other := range replies[k].overtakenBy {
+            j := other - seq
+            if j >= 0 && j < n && replies[j].sent.After(replies[k].sent) {
+                replies[k].reordered = true
             }
-            ttl = header.TTL
-            replyMsg, err = icmp.ParseMessage(1, data[header.Len:])  
-            if err != nil {
-                return
-            }
-        }
-    
-        var body []byte
-        if replyMsg.Type == ipv4.ICMPTypeEchoReply {
-            body, err = replyMsg.Body.Marshal(1)
-        } else if replyMsg.Type == ipv6.ICMPTypeEchoReply {
-            body, err = replyMsg.Body.Marshal(58)
-        } else {
-            continue
         }
-        body = body[4:]
-
-        rtt = float64(time.Now().UnixNano() - 
-            int64(binary.LittleEndian.Uint64(body[:8]))) / 1000000.0
-        return
     }
-    return   
-}
 
-// Print statistics at the end of the program
-func stats(s *statsData) {
-    rttMin, rttMax, rttAvg, rttStd := 0.0, 0.0, 0.0, 0.0
-    if s.recv > 0 {
-        rttMin, rttMax, rttAvg = s.rtts[0], s.rtts[0], s.rtts[0]
-        for i := 1; i < s.recv; i++ {
-            if s.rtts[i] < rttMin {
-                rttMin = s.rtts[i]
-            }
-            if s.rtts[i] > rttMax {
-                rttMax = s.rtts[i]
-            }
-            rttAvg += s.rtts[i]
+    lost := 0
+    for k := 0; k < n; k++ {
+        if errs[k] != nil {
+            lost++
         }
-        rttAvg /= float64(s.recv)
+        record(seq + k, ip, isIPv6, replies[k], errs[k], sent, s)
+    }
+    if n > 1 && lost > 0 {
+        fmt.Fprintf(errOut, "Burst from icmp_seq=%v: %v of %v lost\n",
+            formatSeq(seq), lost, n)
+    }
 
-        for i := 0; i < s.recv; i++ {
-            rttStd += (s.rtts[i] - rttAvg) * (s.rtts[i] - rttAvg)
-        }
-        rttStd = math.Sqrt(rttStd / float64(s.recv))
+    if opts.stopAfter > 0 && s.earlierRecv + s.recv >= opts.stopAfter {
+        return false
+    }
+    if opts.stopOnLoss && s.recv < s.trans {
+        return false
+    }
+    if opts.upAfter > 0 && s.successRun >= opts.upAfter {
+        fmt.Fprintf(errOut, "Up after %v replies in a row\n", s.successRun)
+        return false
     }
 
-    fmt.Println("\n--- Statistics ---")
-    fmt.Printf("%v packets transmitted, %v packets received, %.3f%% packet loss\n",
-        s.trans, s.recv, (1 - float64(s.recv) / float64(s.trans)) * 100)
-    fmt.Printf("round-trip min/avg/max/std-dev = %.3f/%.3f/%.3f/%.3f ms\n", 
-        rttMin, rttAvg, rttMax, rttStd)
+    select {
+    case <-done:
+        return false
+    default:
+    }
+    return true
 }
 
-func main() {
-    size := 56
-    count := flag.Int("c", 0, "the count of echo requests")
-    flag.Usage = func() {
-        fmt.Println("usage: ping [-c count] host")
+// Report whether done is closed, by an interrupt or the deadline
+func stopped(done chan bool) bool {
+    select {
+    case <-done:
+        return true
+    default:
+        return false
     }
-    flag.Parse()
-    
-    if len(flag.Args()) != 1 {
-        flag.Usage()
-        return
+}
+
+// Get the interval doubled for every echo request lost in a row, up to
+// maxBackoffInterval, reporting when backing off starts and ends
+func backoffInterval(s *statsData) time.Duration {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    interval := opts.interval
+    for i := 0; i < s.lossRun && interval < maxBackoffInterval; i++ {
+        interval *= 2
+    }
+    if interval > maxBackoffInterval && opts.interval < maxBackoffInterval {
+        interval = maxBackoffInterval
     }
 
-    host := flag.Args()[0]
-    ip, isIPv6 := resolve(host)
-    if ip == nil {
-        fmt.Println("ping: unknown host")
-        return
+    if s.lossRun > 0 && !s.backedOff {
+        s.backedOff = true
+        fmt.Fprintf(errOut, "Backing off after loss, interval %v\n", interval)
+    } else if s.lossRun == 0 && s.backedOff {
+        s.backedOff = false
+        fmt.Fprintf(errOut, "Replies resumed, interval back to %v\n", interval)
     }
+    return interval
+}
 
-    sigCh := make(chan os.Signal, 1)
-    done := make(chan bool, 1)
-    signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+// Record the result of an echo request and print it
+func record(seq int, ip string, isIPv6 bool, reply echoReply, err error, sent time.Time,
+    s *statsData) {
+    if len(opts.rotateSources) > 0 {
+        recordSource(s, opts.source, reply, err)
+    }
+    if opts.availability > 0 {
+        recordBucket(s, sent, err == nil)
+    }
+    s.trans++
 
-    go func() {
-       <-sigCh
-       done <- true
-    }()
+    // While the network is down, say so once rather than for every echo
+    // request, and again once echo requests go out
+    if err == errNetUnreachable {
+        if !s.netDown {
+            fmt.Fprintln(errOut, "Network is unreachable, retrying quietly")
+        }
+        s.netDown = true
+    } else if s.netDown {
+        fmt.Fprintln(errOut, "Network recovered")
+        s.netDown = false
+    }
 
-    s := statsData{
-        trans: 0,
-        recv: 0,
-        rtts: make([]float64, 0),
+    if events != nil {
+        if err != nil {
+            events.send(failureEvent(seq, reply, err))
+        } else {
+            events.send(replyEvent(seq, reply))
+        }
     }
 
-    fmt.Printf("PING %v (%v): %v data bytes\n", host, ip.String(), size)
-    if *count != 0 {
-        if (*count < 0) {
-            fmt.Println("ping: count must be a positive number")
-            return
+    if err != nil {
+        if opts.ndjson {
+            emitFailure(seq, reply, err)
+        } else if err == errTimeExceeded {
+            fmt.Fprintf(errOut, "From %v: icmp_seq=%v Time to live exceeded\n",
+                displayAddr(reply.from.String()), formatSeq(seq))
+        } else if err == errUnreachable {
+            fmt.Fprintf(errOut, "From %v: icmp_seq=%v Destination unreachable\n",
+                displayAddr(reply.from.String()), formatSeq(seq))
+        } else if err == errTooBig && reply.from != nil {
+            fmt.Fprintf(errOut, "From %v: icmp_seq=%v Packet too big\n",
+                displayAddr(reply.from.String()), formatSeq(seq))
+        } else if err == errTooBig {
+            fmt.Fprintln(errOut, "Echo request too big to send for icmp_seq", formatSeq(seq))
+        } else if err != errNetUnreachable {
+            fmt.Fprintln(errOut, "Request timeout for icmp_seq", formatSeq(seq))
+        }
+
+        cause := causeTimeout
+        wait := float64(opts.timeout) / float64(time.Millisecond)
+        if err == errTimeExceeded {
+            cause, wait = causeTimeExceeded, reply.rtt
+        } else if err == errUnreachable || err == errTooBig {
+            cause, wait = causeUnreachable, reply.rtt
+        } else if err == errNetUnreachable {
+            cause, wait = causeUnreachable, 0
+        }
+        s.causes[cause]++
+        s.causeWait[cause] += wait
+        s.lost = append(s.lost, seq)
+        s.successRun = 0
+        if s.lossRun == 0 {
+            s.lossEvents++
+        }
+        s.lossRun++
+        if s.lossRun > s.longestLoss {
+            s.longestLoss = s.lossRun
+        }
+    } else {
+        s.lossRun = 0
+        s.successRun++
+        s.recv++
+        if s.firstReply == 0 {
+            s.firstReply = time.Since(s.start)
+        }
+        if opts.excludeCold && reply.cold {
+            s.coldReplies++
+        } else {
+            s.rtts = append(s.rtts, reply.rtt)
+            updateSRTT(s, reply.rtt)
+            if opts.watchJitter {
+                watchJitter(s, seq)
+            }
+        }
+        if reply.corrupted {
+            s.corrupted++
+        }
+        if reply.badChecksum {
+            s.badChecksums++
+        }
+        if reply.code != 0 {
+            s.badCodes++
+        }
+        if reply.reordered {
+            s.reordered++
+        }
+        printReply(ip, seq, isIPv6, reply, s)
+    }
+}
+
+// Get the 16-bit sequence number an echo request goes out with, which is
+// shuffled by --icmp-seq-randomize while the payload keeps the real one
+func wireSeq(seq int) int {
+    if opts.seqOrder != nil {
+        return opts.seqOrder[seq & 0xffff]
+    }
+    return seq & 0xffff
+}
+
+// Get the size of a reply as shown, the ICMP message as on Linux or with the
+// IP header as on BSD if asked to. The IPv6 header is counted without any
+// extension headers, which raw sockets do not see
+func replySize(reply echoReply) int {
+    if opts.countIPHeader {
+        return reply.size + reply.headerLen
+    }
+    return reply.size
+}
+
+// Format a sequence number for display, in hex if asked to
+func formatSeq(seq int) string {
+    if opts.seqHex {
+        return fmt.Sprintf("0x%04x", seq)
+    }
+    return strconv.Itoa(seq)
+}
+
+// Print the line for an echo reply
+func printReply(ip string, seq int, isIPv6 bool, reply echoReply, s *statsData) {
+    if opts.ndjson {
+        emitReply(seq, reply)
+        return
+    }
+
+    var param string
+    if isIPv6 {
+        param = "hlim"
+    } else {
+        param = "ttl"
+    }
+    var note string
+    if reply.corrupted {
+        note = " (corrupted packet)"
+    }
+    if reply.badChecksum {
+        note += " (bad checksum)"
+    }
+    if reply.code != 0 {
+        note += fmt.Sprintf(" (code %v, expected 0)", reply.code)
+    }
+    if reply.otherSource {
+        note += fmt.Sprintf(" (reply from %v, expected %v)",
+            displayAddr(reply.from.String()), displayAddr(ip))
+    }
+    if len(opts.rotateSources) > 0 {
+        note += fmt.Sprintf(" (sent from %v)", opts.source)
+    }
+    if reply.idRewritten {
+        note += " (ID was rewritten, NAT?)"
+    }
+    if opts.seqOrder != nil {
+        note += fmt.Sprintf(" (sent as icmp_seq %v)", formatSeq(wireSeq(seq)))
+    }
+    if reply.reordered {
+        note += " (reordered)"
+    }
+    if opts.excludeCold && reply.cold {
+        note += " (cold, not in statistics)"
+    }
+    if opts.headerInclude && !isIPv6 {
+        note += fmt.Sprintf(" id=%v", reply.ipID)
+    }
+    if opts.showIfindex {
+        note += fmt.Sprintf(" (via %v)", interfaceName(reply.ifIndex))
+    }
+    if opts.total > 0 {
+        note += fmt.Sprintf(" (%v/%v)", seq + 1, opts.total)
+    }
+    if opts.upAfter > 0 {
+        note += fmt.Sprintf(" (up %v/%v)", s.successRun, opts.upAfter)
+    }
+    if opts.srtt {
+        note += fmt.Sprintf(" srtt=%.*f rttvar=%.*f", opts.precision, inUnit(s.srtt),
+            opts.precision, inUnit(s.rttvar))
+    }
+    if opts.inlineStats > 0 && s.recv % opts.inlineStats == 0 {
+        _, _, rttAvg, _ := rttStats(s)
+        note += fmt.Sprintf(" [loss=%.1f%% avg=%.*f]", lossPercent(s), opts.precision,
+            inUnit(rttAvg))
+    }
+    if opts.table {
+        tableRow(seq, isIPv6, displayAddr(reply.from.String()), reply.ttl, replySize(reply),
+            reply.rtt, note)
+    } else {
+        fmt.Fprintf(out, "Packet from %v: icmp_seq=%v %v=%v bytes=%v time=%.*f %v%v\n",
+            displayAddr(ip), formatSeq(seq), param, reply.ttl, replySize(reply),
+            opts.precision, inUnit(reply.rtt), opts.unit, note)
+    }
+
+    if opts.tsOption {
+        printTimestamps(reply.options)
+    }
+
+    if opts.dump {
+        dump := reply.payload
+        if len(dump) > maxDumpLen {
+            dump = dump[:maxDumpLen]
+        }
+        fmt.Fprint(out, hex.Dump(dump))
+        if len(reply.payload) > maxDumpLen {
+            fmt.Fprintf(out, "... %v more bytes\n", len(reply.payload) - maxDumpLen)
+        }
+    }
+}
+
+// Get the contents of --payload-file cut or padded with zeros to n bytes
+func filePayload(n int) []byte {
+    payload := make([]byte, n)
+    copy(payload, opts.payload)
+    return payload
+}
+
+// Get where the padding of the payload starts, after the send time, the
+// sequence and any nonce
+func paddingStart() int {
+    if opts.nonce {
+        return payloadHeaderLen + nonceLen
+    }
+    return payloadHeaderLen
+}
+
+// Get n bytes of padding, random ones if --fill-random is given
+func fill(n int) []byte {
+    if opts.randomFill != nil {
+        return opts.randomFill[:n]
+    }
+    return []byte(strings.Repeat(padding, n))
+}
+
+// Check that the padding of a reply payload came back unchanged
+func verifyPayload(payload []byte, dataSize int) bool {
+    if len(payload) != dataSize {
+        return false
+    }
+    return bytes.Equal(payload[paddingStart():], fill(dataSize - paddingStart()))
+}
+
+// What pingOnce needs of a socket, so that a fake returning canned replies
+// can stand in for the network
+type packetConn interface {
+    packetWriter
+    ReadMsgIP(b []byte, oob []byte) (n int, oobn int, flags int, addr *net.IPAddr, err error)
+    SetReadDeadline(t time.Time) error
+    Close() error
+}
+
+// Open the socket for an echo request, which tests replace with a fake
+var dial = func(network string, isIPv6 bool, ttl int) (packetConn, error) {
+    c, err := openConn(network, isIPv6, ttl)
+    if err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+// Send an ICMP echo request with the given TTL, 0 for the system default,
+// and wait for the reply
+func pingOnce(seq int, ip string, isIPv6 bool, dataSize int, ttl int) (reply echoReply,
+    err error) {
+    network := "ip4:icmp"
+    if isIPv6 {
+        network = "ip6:ipv6-icmp"
+    }
+
+    dst, err := net.ResolveIPAddr(network, ip)
+    if err != nil {
+        fmt.Fprintln(errOut, "Error: Failed to resolve destination", err)
+        return
+    }
+
+    c, err := dial(network, isIPv6, ttl)
+    if err != nil {
+        return
+    }
+    defer c.Close()
+
+    return exchange(c, seq, dst, isIPv6, dataSize)
+}
+
+// Open a raw ICMP socket with the options given on the command line
+func openConn(network string, isIPv6 bool, ttl int) (c *net.IPConn, err error) {
+    // Listen rather than dial, so ICMP errors from routers on the way are
+    // received too
+    var laddr *net.IPAddr
+    if opts.source != nil {
+        laddr = &net.IPAddr{IP: opts.source}
+    }
+    c, err = net.ListenIP(network, laddr)
+    if err != nil {
+        fmt.Fprintln(errOut, "Error: Failed to open socket", err)
+        return 
+    }
+    defer func() {
+        if err != nil {
+            c.Close()
+        }
+    }()
+
+    if opts.tos != 0 {
+        if isIPv6 {
+            err = ipv6.NewConn(c).SetTrafficClass(opts.tos)
+        } else {
+            err = ipv4.NewConn(c).SetTOS(opts.tos)
+        }
+        if err != nil {
+            fmt.Fprintln(errOut, "Error: Failed to set TOS", err)
+            return
+        }
+    }
+
+    if ttl != 0 {
+        if isIPv6 {
+            err = ipv6.NewPacketConn(c).SetHopLimit(ttl)
+        } else {
+            err = ipv4.NewPacketConn(c).SetTTL(ttl)
+        }
+        if err != nil {
+            fmt.Fprintln(errOut, "Error: Failed to set TTL", err)
+            return
+        }
+    }
+
+    if opts.tsOption {
+        err = setIPOptions(c, timestampOption())
+        if err != nil {
+            fmt.Fprintln(errOut, "Error: Failed to set timestamp option", err)
+            return
+        }
+    }
+
+    if opts.extHeader != "" {
+        err = setExtHeader(c, opts.extHeader)
+        if err != nil {
+            fmt.Fprintln(errOut, "Error: Failed to set extension header", err)
+            return
+        }
+    }
+
+    // A spoofed source or a given ID goes into an IP header of our own
+    if opts.headerInclude {
+        _, err = ipv4.NewRawConn(c)
+        if err != nil {
+            fmt.Fprintln(errOut, "Error: Failed to include IP header", err)
+            return
+        }
+    }
+
+    // Without it the hop limit of replies is reported as 0
+    if isIPv6 {
+        ipv6.NewPacketConn(c).SetControlMessage(ipv6.FlagHopLimit, true)
+    }
+    if opts.showIfindex {
+        if isIPv6 {
+            err = ipv6.NewPacketConn(c).SetControlMessage(ipv6.FlagInterface, true)
+        } else {
+            err = ipv4.NewPacketConn(c).SetControlMessage(ipv4.FlagInterface, true)
+        }
+        if err != nil {
+            fmt.Fprintln(errOut, "Error: Failed to ask for the receiving interface", err)
+            return
+        }
+    }
+
+    if opts.dontFragment {
+        err = setDontFragment(c, isIPv6)
+        if err != nil {
+            fmt.Fprintln(errOut, "Error: Failed to set don't fragment", err)
+            return
+        }
+    }
+
+    if opts.mark != 0 {
+        err = setMark(c, opts.mark)
+        if err != nil {
+            fmt.Fprintln(errOut, "Error: Failed to set mark", err)
+            return
+        }
+    }
+
+    if opts.device != "" {
+        err = bindToDevice(c, opts.device)
+        if err != nil {
+            fmt.Fprintln(errOut, "Error: Failed to bind to interface", err)
+            return
+        }
+    }
+    return
+}
+
+// Send an echo request over the socket and wait for the reply
+func exchange(c packetConn, seq int, dst *net.IPAddr, isIPv6 bool, dataSize int) (
+    reply echoReply, err error) {
+    timeout := opts.timeout
+    echoMsg, err := echo(seq, isIPv6, dataSize)
+    if err != nil {
+        fmt.Fprintln(errOut, "Error: Failed to marshal echo", err)
+        return
+    }
+
+    // The echo message starts with an 8-byte ICMP header, followed by the
+    // send time, sequence and any nonce unless the payload is from
+    // --payload-file
+    var sentHeader []byte
+    if opts.payload == nil {
+        sentHeader = echoMsg[8:8 + paddingStart()]
+    }
+
+    if opts.headerInclude {
+        echoMsg, err = ipv4Packet(echoMsg, dst.IP)
+        if err != nil {
+            fmt.Fprintln(errOut, "Error: Failed to marshal IP header", err)
+            return
+        }
+    }
+
+    sendTime := time.Now()
+    reply.sent = sendTime
+    err = writeRetry(c, echoMsg, dst)
+    if errors.Is(err, syscall.ENOBUFS) {
+        fmt.Fprintln(errOut, "Error: Failed to send echo request, out of buffer space (transient)")
+        return
+    } else if errors.Is(err, syscall.ENETUNREACH) {
+        // Reported once by the caller while the link is down
+        err = errNetUnreachable
+        return
+    } else if errors.Is(err, syscall.EMSGSIZE) {
+        // Bigger than the outgoing interface allows without fragmenting
+        err = errTooBig
+        return
+    } else if err != nil {
+        fmt.Fprintln(errOut, "Error: Failed to send echo request", err)
+        return
+    }
+
+    data := make([]byte, maxPacketSize)
+    startTime := time.Now()
+    c.SetReadDeadline(startTime.Add(timeout))
+
+    for time.Now().Sub(startTime) < timeout {
+        var n int
+        var replyMsg *icmp.Message
+        if isIPv6 {
+            // Raw ICMPv6 sockets get no IPv6 header, so the hop limit comes
+            // in a control message
+            var peer *net.IPAddr
+            var oobn int
+            oob := ipv6.NewControlMessage(ipv6.FlagHopLimit | ipv6.FlagInterface)
+            n, oobn, _, peer, err = c.ReadMsgIP(data, oob)
+            if err != nil {
+                return
+            }
+            reply.from = peer.IP
+
+            var cm ipv6.ControlMessage
+            if cm.Parse(oob[:oobn]) == nil {
+                reply.ttl = cm.HopLimit
+                reply.ifIndex = cm.IfIndex
+            }
+            reply.size, reply.headerLen = n, ipv6.HeaderLen
+            replyMsg, err = icmp.ParseMessage(58, data[:n])  
+            if err != nil {
+                continue
+            }
+
+        } else {
+            var oobn int
+            oob := ipv4.NewControlMessage(ipv4.FlagInterface)
+            n, oobn, _, _, err = c.ReadMsgIP(data, oob)
+            if err != nil {
+                return
+            }
+
+            var cm ipv4.ControlMessage
+            if cm.Parse(oob[:oobn]) == nil {
+                reply.ifIndex = cm.IfIndex
+            }
+
+            var header *ipv4.Header
+            header, err = icmp.ParseIPv4Header(data[:n])
+            if err != nil {
+                continue
+            }
+            reply.from = header.Src
+            reply.ttl = header.TTL
+            reply.ipID = header.ID
+            reply.options = header.Options
+            reply.size, reply.headerLen = n - header.Len, header.Len
+            replyMsg, err = icmp.ParseMessage(1, data[header.Len:n])  
+            if err != nil {
+                continue
+            }
+
+            // The kernel hands raw IPv4 sockets packets before checking them
+            if opts.validateChecksum {
+                reply.badChecksum = checksum(data[header.Len:n]) != 0
+            }
+        }
+
+        if replyMsg.Type == ipv4.ICMPTypeTimeExceeded ||
+            replyMsg.Type == ipv6.ICMPTypeTimeExceeded {
+            quoted := replyMsg.Body.(*icmp.TimeExceeded).Data
+            if quotesEcho(quoted, isIPv6, seq) {
+                reply.rtt = float64(time.Now().Sub(startTime)) / float64(time.Millisecond)
+                err = errTimeExceeded
+                return
+            }
+            continue
+        }
+
+        if replyMsg.Type == ipv4.ICMPTypeDestinationUnreachable ||
+            replyMsg.Type == ipv6.ICMPTypeDestinationUnreachable {
+            quoted := replyMsg.Body.(*icmp.DstUnreach).Data
+            if quotesEcho(quoted, isIPv6, seq) {
+                reply.rtt = float64(time.Now().Sub(startTime)) / float64(time.Millisecond)
+                err = errUnreachable
+                if !isIPv6 && replyMsg.Code == fragmentationNeeded {
+                    err = errTooBig
+                }
+                return
+            }
+            continue
+        }
+
+        if replyMsg.Type == ipv6.ICMPTypePacketTooBig {
+            quoted := replyMsg.Body.(*icmp.PacketTooBig).Data
+            if quotesEcho(quoted, isIPv6, seq) {
+                reply.rtt = float64(time.Now().Sub(startTime)) / float64(time.Millisecond)
+                err = errTooBig
+                return
+            }
+            continue
+        }
+
+        if replyMsg.Type != ipv4.ICMPTypeEchoReply &&
+            replyMsg.Type != ipv6.ICMPTypeEchoReply {
+            continue
+        }
+        echoBody, ok := replyMsg.Body.(*icmp.Echo)
+        if !ok {
+            continue
+        }
+        body := echoBody.Data
+
+        // A payload from --payload-file carries neither the send time nor
+        // the sequence, so the reply is timed from when it was sent and
+        // matched by the 16-bit sequence of the ICMP header
+        if opts.payload != nil {
+            if echoBody.ID != echoID || echoBody.Seq != wireSeq(seq) {
+                continue
+            }
+            reply.otherSource = !reply.from.Equal(dst.IP)
+            reply.rtt = float64(time.Since(sendTime)) / float64(time.Millisecond)
+            reply.code = replyMsg.Code
+            reply.payload = body
+            reply.corrupted = !bytes.Equal(body, filePayload(dataSize))
+            return
+        }
+
+        // A reply to this echo request that does not carry its nonce was
+        // forged or duplicated from another run, so cannot be trusted
+        if opts.nonce && len(body) >= len(sentHeader) &&
+            bytes.Equal(body[:payloadHeaderLen], sentHeader[:payloadHeaderLen]) &&
+            !bytes.Equal(body[payloadHeaderLen:len(sentHeader)], sentHeader[payloadHeaderLen:]) {
+            fmt.Fprintf(errOut, "From %v: icmp_seq=%v Wrong nonce, reply ignored\n",
+                displayAddr(reply.from.String()), formatSeq(seq))
+            continue
+        }
+
+        // Ignore stale or foreign replies whose payload is not ours. The
+        // send time, sequence and any nonce identify it, while NAT may have
+        // rewritten the identifier
+        if len(body) < len(sentHeader) || !bytes.Equal(body[:len(sentHeader)], sentHeader) {
+            // Note the replies to the rest of a burst that came in first
+            if len(body) >= payloadHeaderLen && echoBody.ID == echoID &&
+                reply.from.Equal(dst.IP) {
+                reply.overtakenBy = append(reply.overtakenBy,
+                    int(binary.LittleEndian.Uint32(body[timestampLen:])))
+            }
+            continue
+        }
+
+        reply.idRewritten = echoBody.ID != echoID
+
+        // Anycast or NAT can answer from another address than the destination
+        reply.otherSource = !reply.from.Equal(dst.IP)
+
+        // The send time in the payload only identifies the reply. The wall
+        // clock may step while waiting, so it is timed by the monotonic clock
+        reply.rtt = float64(time.Since(sendTime)) / float64(time.Millisecond)
+
+        // Echo replies have code 0, but some broken stacks set another
+        reply.code = replyMsg.Code
+        reply.payload = body
+        reply.corrupted = !verifyPayload(body, dataSize)
+        return
+    }
+    err = os.ErrDeadlineExceeded
+    return   
+}
+
+// Check that a raw ICMP socket can be opened, explaining how to get the
+// privileges it needs if not
+func checkRawSocket(isIPv6 bool) error {
+    network := "ip4:icmp"
+    if isIPv6 {
+        network = "ip6:ipv6-icmp"
+    }
+
+    c, err := net.ListenIP(network, nil)
+    if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+        return fmt.Errorf("permission denied opening a raw ICMP socket, " +
+            "run as root (sudo ping ...) or grant the binary the capability " +
+            "(sudo setcap cap_net_raw+ep ping)")
+    } else if err != nil {
+        // Anything else is reported when pinging
+        return nil
+    }
+    c.Close()
+    return nil
+}
+
+// Something echo requests can be written to
+type packetWriter interface {
+    WriteTo(b []byte, addr net.Addr) (int, error)
+}
+
+// Write the packet, retrying with a short backoff while the kernel is out of
+// buffer space, which happens when sending fast
+func writeRetry(w packetWriter, b []byte, dst net.Addr) (err error) {
+    backoff := time.Millisecond
+    for i := 0; ; i++ {
+        _, err = w.WriteTo(b, dst)
+        if !errors.Is(err, syscall.ENOBUFS) || i == writeRetries {
+            return
+        }
+        time.Sleep(backoff)
+        backoff *= 2
+    }
+}
+
+// Compute the Internet checksum (RFC 1071), which sums to 0 over a message
+// carrying a valid checksum
+func checksum(b []byte) uint16 {
+    var sum uint32
+    for i := 0; i + 1 < len(b); i += 2 {
+        sum += uint32(b[i]) << 8 | uint32(b[i + 1])
+    }
+    if len(b) % 2 == 1 {
+        sum += uint32(b[len(b) - 1]) << 8
+    }
+    for sum > 0xffff {
+        sum = sum >> 16 + sum & 0xffff
+    }
+    return ^uint16(sum)
+}
+
+// Check if the datagram quoted by an ICMP error is our echo request with the
+// given sequence number
+func quotesEcho(quoted []byte, isIPv6 bool, seq int) bool {
+    headerLen := ipv6.HeaderLen
+    if !isIPv6 {
+        if len(quoted) < ipv4.HeaderLen {
+            return false
+        }
+        headerLen = int(quoted[0] & 0x0f) << 2
+    }
+    if len(quoted) < headerLen + 8 {
+        return false
+    }
+
+    msg := quoted[headerLen:]
+    return int(binary.BigEndian.Uint16(msg[4:6])) == echoID &&
+        int(binary.BigEndian.Uint16(msg[6:8])) == wireSeq(seq)
+}
+
+// Ping the default gateway to tell a broken local network from an
+// unreachable target
+func checkGateway(isIPv6 bool, dataSize int) {
+    gw, err := defaultGateway(isIPv6)
+    if err != nil {
+        fmt.Fprintln(errOut, "ping: cannot determine default gateway:", err)
+        return
+    }
+
+    for i := 0; i < 3; i++ {
+        reply, err := pingOnce(i, gw, isIPv6, dataSize, opts.ttl)
+        if err == nil {
+            fmt.Fprintf(errOut, "Gateway %v is reachable: time=%.*f %v\n",
+                gw, opts.precision, inUnit(reply.rtt), opts.unit)
+            return
+        }
+    }
+    fmt.Fprintf(errOut, "Gateway %v is not reachable, the local network may be down\n", gw)
+}
+
+// Ping for the given rounds of count times, pausing between rounds, with a
+// summary of each round unless summaries is false
+func pingRounds(ip string, isIPv6 bool, dataSize int, count int, rounds int,
+    gap time.Duration, summaries bool, s *statsData, done chan bool) {
+    for r := 1; r <= rounds; r++ {
+        // A run of replies carries on into the next round, for --up-after, and
+        // --stop-after-received counts the replies of the earlier rounds too
+        s.mu.Lock()
+        round := statsData{
+            trans: 0,
+            recv: 0,
+            rtts: make([]float64, 0),
+            start: time.Now(),
+            successRun: s.successRun,
+            earlierRecv: s.recv,
+        }
+        s.round = &round
+        s.mu.Unlock()
+        pingForTimes(ip, isIPv6, dataSize, count, &round, done)
+        if summaries {
+            stats(&round, fmt.Sprintf("Round %v/%v", r, rounds))
+        }
+
+        s.mu.Lock()
+        mergeRound(s, &round)
+        s.round = nil
+        up := opts.upAfter > 0 && s.successRun >= opts.upAfter
+        received := opts.stopAfter > 0 && s.recv >= opts.stopAfter
+        s.mu.Unlock()
+
+        if r == rounds || opts.stopOnLoss && round.recv < round.trans || up || received {
+            return
+        }
+
+        select {
+        case <-done:
+            return
+        case <-time.After(gap):
+        }
+    }
+}
+
+// Add the statistics of a round to those of the whole run, with the lock
+// of the run held. Every round counts its sequence from 0, so the lost echo
+// requests are numbered on from those sent in the earlier rounds
+func mergeRound(s *statsData, round *statsData) {
+    for _, seq := range round.lost {
+        s.lost = append(s.lost, s.trans + seq)
+    }
+    s.trans += round.trans
+    s.recv += round.recv
+    s.coldReplies += round.coldReplies
+    s.reordered += round.reordered
+    if s.firstReply == 0 && round.firstReply > 0 {
+        s.firstReply = round.start.Sub(s.start) + round.firstReply
+    }
+    s.corrupted += round.corrupted
+    s.badChecksums += round.badChecksums
+    s.badCodes += round.badCodes
+    s.lossEvents += round.lossEvents
+    s.successRun = round.successRun
+    mergeBuckets(s, round)
+    if round.longestLoss > s.longestLoss {
+        s.longestLoss = round.longestLoss
+    }
+    s.rtts = append(s.rtts, round.rtts...)
+    if round.srttSet {
+        s.srtt, s.rttvar, s.srttSet = round.srtt, round.rttvar, true
+    }
+    for cause := range round.causes {
+        s.causes[cause] += round.causes[cause]
+        s.causeWait[cause] += round.causeWait[cause]
+    }
+    for source, src := range round.bySource {
+        if s.bySource == nil {
+            s.bySource = make(map[string]*statsData)
+        }
+        total, ok := s.bySource[source]
+        if !ok {
+            total = &statsData{rtts: make([]float64, 0)}
+            s.bySource[source] = total
+        }
+        total.trans += src.trans
+        total.recv += src.recv
+        total.rtts = append(total.rtts, src.rtts...)
+    }
+}
+
+// The statistics of the run so far including the round in progress, for a
+// snapshot taken in the middle of a round
+func withRound(s *statsData) *statsData {
+    total := &statsData{
+        rtts: make([]float64, 0),
+        start: s.start,
+    }
+    mergeRound(total, s)
+    s.round.mu.Lock()
+    mergeRound(total, s.round)
+    s.round.mu.Unlock()
+    return total
+}
+
+// Start the statistics afresh after a report, keeping the state that
+// carries across reports: the current loss run, smoothed round-trip time,
+// jitter, backoff and the time to the first reply
+func resetStats(s *statsData) {
+    s.trans = 0
+    s.recv = 0
+    s.corrupted = 0
+    s.badChecksums = 0
+    s.badCodes = 0
+    s.rtts = make([]float64, 0)
+    s.longestLoss = 0
+    s.lossEvents = 0
+    s.start = time.Now()
+    s.bySource = nil
+    s.lost = nil
+    s.causes = [numCauses]int{}
+    s.causeWait = [numCauses]float64{}
+    s.buckets = nil
+    s.coldReplies = 0
+    s.reordered = 0
+}
+
+// Compute the round-trip time statistics of the received replies
+func rttStats(s *statsData) (rttMin float64, rttMax float64, rttAvg float64,
+    rttStd float64) {
+    // Cold replies left out by --exclude-cold are received but have no time
+    n := len(s.rtts)
+    if n > 0 {
+        rttMin, rttMax, rttAvg = s.rtts[0], s.rtts[0], s.rtts[0]
+        for i := 1; i < n; i++ {
+            if s.rtts[i] < rttMin {
+                rttMin = s.rtts[i]
+            }
+            if s.rtts[i] > rttMax {
+                rttMax = s.rtts[i]
+            }
+            rttAvg += s.rtts[i]
+        }
+        rttAvg /= float64(n)
+
+        for i := 0; i < n; i++ {
+            rttStd += (s.rtts[i] - rttAvg) * (s.rtts[i] - rttAvg)
+        }
+        rttStd = math.Sqrt(rttStd / float64(n))
+    }
+    return
+}
+
+// Update the smoothed round-trip time and its variation with a new sample,
+// the way TCP does (RFC 6298)
+func updateSRTT(s *statsData, rtt float64) {
+    if !s.srttSet {
+        s.srtt = rtt
+        s.rttvar = rtt / 2
+        s.srttSet = true
+        return
+    }
+    s.rttvar = 0.75 * s.rttvar + 0.25 * math.Abs(s.srtt - rtt)
+    s.srtt = 0.875 * s.srtt + 0.125 * rtt
+}
+
+// Convert a time in ms to the unit given by --unit for display
+func inUnit(ms float64) float64 {
+    if opts.unit == "us" {
+        return ms * 1000
+    }
+    return ms
+}
+
+// Compute the percentage of replies whose round-trip time was within --sla-rtt
+func slaPercent(s *statsData) float64 {
+    if len(s.rtts) == 0 {
+        return 0
+    }
+    within := 0
+    for _, rtt := range s.rtts {
+        if rtt <= opts.slaRTT {
+            within++
+        }
+    }
+    return float64(within) / float64(len(s.rtts)) * 100
+}
+
+// Compute the mean round-trip time without the --trimmed-mean percent of
+// fastest and slowest replies
+func trimmedMean(s *statsData) float64 {
+    sorted := append([]float64(nil), s.rtts...)
+    sort.Float64s(sorted)
+    cut := int(float64(len(sorted)) * opts.trimmedMean / 100)
+    sorted = sorted[cut:len(sorted) - cut]
+    if len(sorted) == 0 {
+        return 0
+    }
+
+    sum := 0.0
+    for _, rtt := range sorted {
+        sum += rtt
+    }
+    return sum / float64(len(sorted))
+}
+
+// Compute the percentage of lost packets
+func lossPercent(s *statsData) float64 {
+    if s.trans == 0 {
+        return 0
+    }
+    return (1 - float64(s.recv) / float64(s.trans)) * 100
+}
+
+// Print statistics under the given title
+func stats(s *statsData, title string) {
+    rttMin, rttMax, rttAvg, rttStd := rttStats(s)
+
+    fmt.Fprintf(out, "\n--- %v ---\n", title)
+    var corrupted string
+    if s.corrupted > 0 {
+        corrupted = fmt.Sprintf("%v corrupted, ", s.corrupted)
+    }
+    if s.badChecksums > 0 {
+        corrupted += fmt.Sprintf("%v bad checksums, ", s.badChecksums)
+    }
+    if s.badCodes > 0 {
+        corrupted += fmt.Sprintf("%v nonzero codes, ", s.badCodes)
+    }
+    fmt.Fprintf(out, "%v packets transmitted, %v packets received, %v%.3f%% packet loss\n",
+        s.trans, s.recv, corrupted, lossPercent(s))
+    fmt.Fprintf(out, "longest loss burst: %v, loss events: %v\n",
+        s.longestLoss, s.lossEvents)
+    if opts.showGaps && len(s.lost) > 0 {
+        fmt.Fprintln(out, "missing:", formatGaps(s.lost))
+    }
+    if opts.failureCauses && s.trans > s.recv {
+        failureStats(s)
+    }
+    fmt.Fprintf(out, "run duration: %.1fs\n", time.Since(s.start).Seconds())
+    p := opts.precision
+    if s.firstReply > 0 {
+        fmt.Fprintf(out, "time to first reply: %.*f %v\n",
+            p, inUnit(float64(s.firstReply) / float64(time.Millisecond)), opts.unit)
+    } else {
+        fmt.Fprintln(out, "time to first reply: never")
+    }
+    fmt.Fprintf(out, "round-trip min/avg/max/std-dev = %.*f/%.*f/%.*f/%.*f %v\n",
+        p, inUnit(rttMin), p, inUnit(rttAvg), p, inUnit(rttMax), p, inUnit(rttStd), opts.unit)
+    if opts.trimmedMean > 0 {
+        fmt.Fprintf(out, "round-trip trimmed mean = %.*f %v (%v%% trimmed at each end)\n",
+            p, inUnit(trimmedMean(s)), opts.unit, opts.trimmedMean)
+    }
+    if s.coldReplies > 0 {
+        fmt.Fprintf(out, "%v cold replies left out of the round-trip times\n", s.coldReplies)
+    }
+    if s.reordered > 0 {
+        fmt.Fprintf(out, "%v replies arrived out of order\n", s.reordered)
+    }
+    if opts.srtt && len(s.rtts) > 0 {
+        fmt.Fprintf(out, "smoothed round-trip srtt/rttvar = %.*f/%.*f %v\n",
+            p, inUnit(s.srtt), p, inUnit(s.rttvar), opts.unit)
+    }
+    if opts.slaRTT > 0 {
+        fmt.Fprintf(out, "%.1f%% of replies within %v ms\n", slaPercent(s), opts.slaRTT)
+    }
+    if s.bySource != nil {
+        sourceStats(s)
+    }
+    if opts.availability > 0 {
+        availabilityStats(s)
+    }
+}
+
+// Print how many echo requests failed for each cause, and how long they
+// waited on average before failing
+func failureStats(s *statsData) {
+    var causes []string
+    for cause, n := range s.causes {
+        if n > 0 {
+            causes = append(causes, fmt.Sprintf("%v %v (avg wait %.*f %v)",
+                n, causeNames[cause], opts.precision, inUnit(s.causeWait[cause] / float64(n)),
+                opts.unit))
+        }
+    }
+    fmt.Fprintln(out, "failures:", strings.Join(causes, ", "))
+}
+
+// Explain what it likely means when no echo request got a reply, telling a
+// host that is silent, often because a firewall drops ICMP, from one that the
+// network reported as unreachable
+func silenceNote(s *statsData, host string) {
+    if s.trans == 0 || s.recv > 0 {
+        return
+    }
+    if s.causes[causeUnreachable] > 0 {
+        fmt.Fprintf(errOut, "Note: %v resolved but the network reported it unreachable\n", host)
+    } else if s.causes[causeTimeExceeded] > 0 {
+        fmt.Fprintf(errOut, "Note: %v resolved but echo requests expired on the way, " +
+            "it may be further than the TTL or behind a routing loop\n", host)
+    } else {
+        fmt.Fprintf(errOut, "Note: %v resolved but sent no ICMP replies, " +
+            "it may be filtering ICMP rather than be down\n", host)
+    }
+}
+
+// Format ascending sequence numbers, collapsing consecutive ones into ranges,
+// e.g. 4, 7-9, 15
+func formatGaps(seqs []int) string {
+    var ranges []string
+    for i := 0; i < len(seqs); {
+        j := i
+        for j + 1 < len(seqs) && seqs[j + 1] == seqs[j] + 1 {
+            j++
+        }
+        if j == i {
+            ranges = append(ranges, formatSeq(seqs[i]))
+        } else {
+            ranges = append(ranges, formatSeq(seqs[i]) + "-" + formatSeq(seqs[j]))
+        }
+        i = j + 1
+    }
+    return strings.Join(ranges, ", ")
+}
+
+// Quote a logfmt value if it cannot be written bare
+func logfmtValue(v string) string {
+    if v == "" || strings.ContainsAny(v, " \"=") {
+        return strconv.Quote(v)
+    }
+    return v
+}
+
+// Print statistics as a single logfmt line
+func logfmtStats(s *statsData, host string, ip string) {
+    rttMin, rttMax, rttAvg, rttStd := rttStats(s)
+    p := opts.precision
+    var sla string
+    if opts.slaRTT > 0 {
+        sla = fmt.Sprintf(" sla_percent=%.1f", slaPercent(s))
+    }
+    fmt.Fprintf(out, "host=%v ip=%v sent=%v recv=%v loss=%.1f rtt_min=%.*f " +
+        "rtt_avg=%.*f rtt_max=%.*f rtt_stddev=%.*f%v\n",
+        logfmtValue(host), ip, s.trans, s.recv, lossPercent(s),
+        p, rttMin, p, rttAvg, p, rttMax, p, rttStd, sla)
+}
+
+// Report wrong arguments and exit, with an NDJSON event too under --ndjson
+// so that scripts reading only standard output learn why there is no result
+func usageError(a ...interface{}) {
+    msg := fmt.Sprint(a...)
+    if opts.ndjson {
+        emitUsageError(msg)
+    }
+    fmt.Fprintln(errOut, "ping:", msg)
+    os.Exit(exitUsage)
+}
+
+// Report an error that keeps ping from running and exit
+func fatal(a ...interface{}) {
+    fmt.Fprintln(errOut, append([]interface{}{"ping:"}, a...)...)
+    os.Exit(exitFailure)
+}
+
+func main() {
+    size := 56
+    count := flag.Int("c", 0, "the count of echo requests")
+    flag.IntVar(&size, "s", size, "the number of data bytes of echo requests")
+    opts.interval = time.Second
+    flag.Var((*durationValue)(&opts.interval), "i",
+        "the interval between echo requests, in seconds or with a unit")
+    opts.timeout = time.Second
+    flag.Var((*durationValue)(&opts.timeout), "W",
+        "the time to wait for each reply, in seconds or with a unit")
+    flag.Var((*durationValue)(&opts.deadlineGrace), "deadline-grace",
+        "the extra time to wait for the reply to the last of -c echo requests")
+    flag.Var((*durationValue)(&opts.availability), "availability",
+        "report the availability in windows of this duration in the summary")
+    opts.reverseTimeout = 500 * time.Millisecond
+    flag.Var((*durationValue)(&opts.reverseTimeout), "reverse-dns-timeout",
+        "the time to wait for a reverse DNS lookup before showing the address")
+    var deadline time.Duration
+    flag.Var((*durationValue)(&deadline), "w",
+        "the time to stop after, in seconds or with a unit")
+    flag.IntVar(&opts.upAfter, "up-after", 0,
+        "stop once this many replies in a row came back, exiting with status 1 if not")
+    runRetries := flag.Int("run-retries", 0,
+        "the number of times to repeat a run of -c echo requests that got no reply")
+    retryDelay := 5 * time.Second
+    flag.Var((*durationValue)(&retryDelay), "run-retry-delay",
+        "the pause before repeating a run that got no reply")
+    allowFlood := flag.Bool("allow-flood", false,
+        "allow intervals below " + minInterval.String())
+    flag.IntVar(&opts.precision, "precision", 3,
+        "the number of decimal places of round-trip times")
+    source := flag.String("I", "", "the source address or interface name")
+    flag.IntVar(&opts.ttl, "t", 0, "the TTL or hop limit of echo requests")
+    flag.StringVar(&opts.extHeader, "ext-header", "",
+        "add an empty IPv6 extension header, hbh for hop-by-hop or dst for destination options")
+    flag.IntVar(&opts.icmpType, "icmp-type", -1,
+        "send this ICMP type instead of echo request, requires --raw-icmp")
+    flag.IntVar(&opts.icmpCode, "icmp-code", 0,
+        "send this ICMP code instead of 0, requires --raw-icmp")
+    rawICMP := flag.Bool("raw-icmp", false,
+        "acknowledge that --icmp-type and --icmp-code send non-standard packets")
+    flag.BoolVar(&opts.tsOption, "ts-option", false,
+        "ask routers to record addresses and timestamps in an IPv4 option")
+    fillRandom := flag.Bool("fill-random", false,
+        "fill the payload with random bytes, drawn once for the run")
+    flag.BoolVar(&opts.showGaps, "show-gaps", false,
+        "list the sequence numbers that got no reply in the summary")
+    flag.BoolVar(&opts.watchJitter, "watch-jitter", false,
+        "warn when the round-trip times become unstable")
+    flag.StringVar(&opts.unit, "unit", "ms", "the unit of displayed round-trip times, ms or us")
+    flag.Float64Var(&opts.trimmedMean, "trimmed-mean", 0,
+        "the percentage of fastest and slowest replies to leave out of a trimmed mean")
+    flag.Float64Var(&opts.slaRTT, "sla-rtt", 0,
+        "report the percentage of replies within this round-trip time in ms")
+    slaMin := flag.Float64("sla-min", 0,
+        "exit with status 4 if less than this percentage of replies are within --sla-rtt")
+    flag.IntVar(&opts.burst, "burst", 1,
+        "the number of echo requests to send back to back each interval")
+    flag.BoolVar(&opts.failureCauses, "probe-timeout-distribution", false,
+        "break down failed echo requests by cause in the summary")
+    flag.IntVar(&opts.mark, "mark", 0,
+        "the firewall mark of echo requests, to select a policy routing table")
+    flag.BoolVar(&opts.table, "table", false,
+        "print replies in aligned columns under a header")
+    flag.BoolVar(&opts.stopOnLoss, "stop-on-loss", false,
+        "stop with exit status 1 as soon as an echo request gets no reply")
+    autoSource := flag.Bool("interface-source-auto", false,
+        "send from the source address the kernel would pick for the host")
+    flag.IntVar(&opts.inlineStats, "inline-stats", 0,
+        "append the running loss and average round-trip time to every nth reply")
+    dnsServer := flag.String("dns-server", "",
+        "the DNS server to resolve the host with, as ip:port")
+    flag.BoolVar(&opts.srtt, "srtt", false,
+        "show the smoothed round-trip time and its variation, computed like TCP")
+    showTotal := flag.Bool("count-display-total", false,
+        "append the progress through -c echo requests to each reply, e.g. (3/10)")
+    flag.BoolVar(&opts.adaptiveInterval, "interval-adaptive-on-loss", false,
+        "double the interval for every echo request lost in a row, up to " +
+        maxBackoffInterval.String())
+    spoofSource := flag.String("spoof-source", "",
+        "forge this IPv4 source address, for lab testing only")
+    spoofConfirmed := flag.Bool("i-know-this-is-spoofing", false,
+        "confirm sending with the source address given by --spoof-source")
+    compareHost := flag.String("compare", "",
+        "ping this second host alongside the host and compare them")
+    textfile := flag.String("textfile", "",
+        "write the statistics to this file for the node_exporter textfile collector")
+    flag.BoolVar(&opts.reverseDNS, "reverse-dns", false,
+        "show the names of addresses by reverse DNS")
+    flag.BoolVar(&opts.showIfindex, "show-ifindex", false,
+        "show the interface each reply arrived on")
+    eventSocketPath := flag.String("event-socket", "",
+        "the Unix domain socket to write each result to as a JSON line")
+    webhook := flag.String("webhook", "",
+        "POST the JSON summary to this URL when the run ends")
+    ipID := flag.String("ip-id", "",
+        "the IPv4 identification of echo requests, a number or random")
+    resetOnReport := flag.Bool("stats-reset-on-report", false,
+        "make each SIGQUIT snapshot and the final summary cover only the time " +
+        "since the previous snapshot rather than the whole run")
+    flag.BoolVar(&opts.excludeCold, "exclude-cold", false,
+        "leave the first reply, and any after a gap of " + coldGap.String() +
+        ", out of the round-trip times")
+    ipv4Only := flag.Bool("4", false, "use IPv4 addresses of the host only")
+    ipv6Only := flag.Bool("6", false, "use IPv6 addresses of the host only")
+    flag.StringVar(&opts.selectAddr, "select", "first",
+        "which resolved address to ping: first, last or random")
+    flag.BoolVar(&opts.countIPHeader, "count-ip-header", false,
+        "count the IP header in the size of replies")
+    seqRandomize := flag.Bool("icmp-seq-randomize", false,
+        "send echo requests with shuffled ICMP sequence numbers")
+    guessMTU := flag.Bool("path-mtu", false,
+        "guess the path MTU with don't fragment echo requests before pinging")
+    showAddrs := flag.Bool("show-addresses", false,
+        "print all addresses the host resolves to before pinging")
+    seed := flag.Int64("seed", 0, "the seed for --select random, 0 for the time")
+    serveAddr := flag.String("serve", "",
+        "serve GET /ping?host=X&count=N on this address instead of pinging a host")
+    flag.BoolVar(&opts.nonce, "nonce", false,
+        "put a random nonce in each echo request that its reply must carry")
+    payloadFile := flag.String("payload-file", "",
+        "send the contents of this file as the payload, cut or padded with zeros to -s bytes")
+    minRTTOnly := flag.Bool("min-rtt-only", false,
+        "ping as fast as allowed and print only the lowest round-trip time")
+    nextHop := flag.String("gateway", "",
+        "send through the interface on the subnet of this gateway")
+    rotateSource := flag.Bool("rotate-source", false,
+        "cycle the source through the addresses of this host")
+    happyEyeballs := flag.Bool("happy-eyeballs", false,
+        "ping the first IPv4 and IPv6 addresses of the host at once and report the fastest")
+    flag.BoolVar(&opts.validateChecksum, "validate-checksum", false,
+        "verify the ICMP checksum of IPv4 replies")
+    autoSize := flag.Bool("auto-size", false,
+        "fill the MTU of the outgoing interface with each echo request")
+    traceroute := flag.Bool("traceroute", false,
+        "trace the route to the host by raising the TTL from 1")
+    maxHops := flag.Int("max-hops", 30, "the highest TTL to trace with")
+    probes := flag.Int("probes", 3, "the number of probes for each hop")
+    tos := flag.String("Q", "",
+        "the TOS byte as a number, DSCP class and ECN names, e.g. af41,ect0")
+    var resolveTimeout time.Duration
+    flag.Var((*durationValue)(&resolveTimeout), "resolve-timeout",
+        "the time to wait for the host to resolve, 0 to wait indefinitely")
+    gateway := flag.Bool("check-gateway", false,
+        "ping the default gateway before the host")
+    rounds := flag.Int("rounds", 1, "the number of rounds of -c echo requests")
+    gap := time.Minute
+    flag.Var((*durationValue)(&gap), "round-gap", "the pause between rounds")
+    sweep := flag.String("size-sweep", "",
+        "ping once with each payload size of start:end:step")
+    flag.BoolVar(&opts.dump, "dump", false, "print a hex dump of each reply payload")
+    flag.BoolVar(&opts.seqHex, "seq-hex", false, "print sequence numbers in hex")
+    flag.IntVar(&opts.stopAfter, "stop-after-received", 0,
+        "stop after receiving the count of replies")
+    logfmt := flag.Bool("logfmt", false, "print the summary as a logfmt line")
+    flag.BoolVar(&opts.ndjson, "ndjson", false,
+        "print each result and the summary as a JSON object per line")
+    noStats := flag.Bool("no-stats", false, "do not print the summary at the end")
+    summaryJSONOnSignal := flag.Bool("summary-json-on-signal", false,
+        "print the snapshot on SIGQUIT as a JSON object")
+    oneWayMode := flag.Bool("one-way", false,
+        "listen for echo requests from the host and estimate the one-way delay," +
+        " which requires synchronized clocks on both hosts")
+    outfile := flag.String("outfile", "", "the file to append results to")
+    outfileOnly := flag.Bool("outfile-only", false,
+        "write results to the outfile only, not to the standard output")
+    flag.Usage = func() {
+        fmt.Fprintln(os.Stderr, "usage: ping [options] host")
+        flag.PrintDefaults()
+    }
+
+    // Wrong flags are reported by usageError, so that --ndjson tells of them
+    // on standard output too
+    flag.CommandLine.Init("ping", flag.ContinueOnError)
+    flag.CommandLine.Usage = func() {}
+    flag.CommandLine.SetOutput(io.Discard)
+    err := flag.CommandLine.Parse(os.Args[1:])
+    flag.CommandLine.SetOutput(os.Stderr)
+    if err == flag.ErrHelp {
+        flag.Usage()
+        os.Exit(0)
+    }
+    if err != nil {
+        usageError(err)
+    }
+    
+    if len(flag.Args()) != 1 && !(*serveAddr != "" && len(flag.Args()) == 0) {
+        if opts.ndjson {
+            emitUsageError("expected one host")
+        }
+        flag.Usage()
+        os.Exit(exitUsage)
+    }
+
+    if opts.precision < 0 {
+        usageError("precision must not be negative")
+    }
+
+    if opts.interval < 0 {
+        usageError("interval must not be negative")
+    }
+    if opts.timeout <= 0 {
+        usageError("timeout must be a positive duration")
+    }
+    if deadline < 0 {
+        usageError("deadline must be a positive duration")
+    }
+    if opts.interval < minInterval && !*allowFlood {
+        fmt.Fprintf(errOut, "ping: interval %v is below %v, using %v (use --allow-flood to override)\n",
+            opts.interval, minInterval, minInterval)
+        opts.interval = minInterval
+    }
+
+    // 0 is only the default leaving the TTL to the system, not a valid TTL
+    ttlSet := false
+    flag.Visit(func(f *flag.Flag) {
+        ttlSet = ttlSet || f.Name == "t"
+    })
+    if (ttlSet && opts.ttl < 1) || opts.ttl < 0 || opts.ttl > 255 {
+        usageError("TTL must be between 1 and 255")
+    }
+    if *maxHops < 1 || *maxHops > 255 {
+        usageError("max-hops must be between 1 and 255")
+    }
+    if *probes < 1 {
+        usageError("probes must be a positive number")
+    }
+
+    // -1 is only the default standing for an echo request, not a valid type
+    icmpTypeSet := false
+    flag.Visit(func(f *flag.Flag) {
+        icmpTypeSet = icmpTypeSet || f.Name == "icmp-type"
+    })
+    if (icmpTypeSet && opts.icmpType < 0) || opts.icmpType > 255 ||
+        opts.icmpCode < 0 || opts.icmpCode > 255 {
+        usageError("ICMP type and code must be between 0 and 255")
+    }
+    if (opts.icmpType >= 0 || opts.icmpCode != 0) && !*rawICMP {
+        usageError("icmp-type and icmp-code send non-standard packets, " +
+            "add --raw-icmp to confirm")
+    }
+
+    if opts.stopAfter < 0 {
+        usageError("stop-after-received must be a positive number")
+    }
+    if opts.inlineStats < 0 {
+        usageError("inline-stats must not be negative")
+    }
+
+    if *rounds < 1 {
+        usageError("rounds must be a positive number")
+    }
+    if *rounds > 1 && *count == 0 {
+        usageError("rounds require a count given by -c")
+    }
+
+    // Elsewhere these cannot be set on the socket, so every echo request
+    // would fail
+    if runtime.GOOS != "linux" {
+        linuxOnly := []struct {
+            name string
+            given bool
+        }{
+            {"mark", opts.mark != 0},
+            {"gateway", *nextHop != ""},
+            {"ext-header", opts.extHeader != ""},
+            {"ts-option", opts.tsOption},
+            {"path-mtu", *guessMTU},
+        }
+        for _, f := range linuxOnly {
+            if f.given {
+                usageError(f.name, " is only supported on Linux")
+            }
+        }
+    }
+
+    if *tos != "" {
+        var err error
+        opts.tos, err = parseTOS(*tos)
+        if err != nil {
+            usageError(err)
+        }
+    }
+
+    if *payloadFile != "" {
+        if *fillRandom || opts.nonce {
+            usageError("payload-file cannot be combined with " +
+                "fill-random or nonce")
+        }
+        var err error
+        opts.payload, err = os.ReadFile(*payloadFile)
+        if err != nil {
+            fatal("cannot read payload file:", err)
+        }
+    }
+    if err := checkSize(size); err != nil {
+        usageError(err)
+    }
+
+    var sweepStart, sweepEnd, sweepStep int
+    if *sweep != "" {
+        var err error
+        sweepStart, sweepEnd, sweepStep, err = parseSizeSweep(*sweep)
+        if err != nil {
+            usageError(err)
+        }
+    }
+
+    // Each 16-bit sequence number is used once before they wrap around
+    if *seqRandomize {
+        opts.seqOrder = rand.New(rand.NewSource(time.Now().UnixNano())).Perm(1 << 16)
+    }
+
+    // Drawn once for the largest payload, so replies can be checked against it
+    if *fillRandom {
+        opts.randomFill = make([]byte, maxDataSize)
+        rand.New(rand.NewSource(time.Now().UnixNano())).Read(opts.randomFill)
+    }
+
+    if *showTotal {
+        if *count <= 0 {
+            usageError("count-display-total requires -c")
+        }
+        opts.total = *count
+    }
+
+    if *ipv4Only && *ipv6Only {
+        usageError("-4 and -6 cannot be combined")
+    } else if *ipv4Only {
+        opts.family = 4
+    } else if *ipv6Only {
+        opts.family = 6
+    }
+
+    selectRand, err := parseSelect(opts.selectAddr, *seed)
+    if err != nil {
+        usageError(err)
+    }
+    opts.selectRand = selectRand
+
+    if *eventSocketPath != "" {
+        events = &eventSocket{path: *eventSocketPath}
+    }
+
+    if *webhook != "" {
+        if err := checkWebhook(*webhook); err != nil {
+            usageError(err)
+        }
+    }
+
+    if *dnsServer != "" {
+        var err error
+        resolver, err = serverResolver(*dnsServer)
+        if err != nil {
+            usageError(err)
+        }
+    }
+
+    if opts.burst < 1 {
+        usageError("burst must be a positive number")
+    }
+
+    if opts.upAfter < 0 {
+        usageError("up-after must not be negative")
+    }
+
+    if *runRetries < 0 {
+        usageError("run-retries must not be negative")
+    } else if *runRetries > 0 && *count <= 0 {
+        usageError("run-retries requires -c")
+    }
+
+    if opts.unit != "ms" && opts.unit != "us" {
+        usageError("unit must be ms or us")
+    }
+
+    if opts.trimmedMean < 0 || opts.trimmedMean > 25 {
+        usageError("trimmed-mean must be between 0 and 25 percent")
+    }
+
+    if *slaMin > 0 && opts.slaRTT <= 0 {
+        usageError("sla-min requires sla-rtt")
+    }
+
+    // These modes print their own lines rather than events
+    if opts.ndjson && (*oneWayMode || *traceroute || *minRTTOnly ||
+        *compareHost != "" || *sweep != "" || *happyEyeballs) {
+        usageError("ndjson cannot be combined with one-way, traceroute, " +
+            "min-rtt-only, compare, size-sweep or happy-eyeballs")
+    }
+
+    if *outfileOnly && *outfile == "" {
+        usageError("outfile-only requires an outfile")
+    }
+    if *outfile != "" {
+        f, err := openOutputFile(*outfile)
+        if err != nil {
+            fatal("cannot open outfile:", err)
+        }
+        defer f.Close()
+
+        // Reopen the outfile on SIGHUP, after logrotate has moved it away
+        hupCh := make(chan os.Signal, 1)
+        signal.Notify(hupCh, syscall.SIGHUP)
+        go func() {
+            for range hupCh {
+                if err := f.Reopen(); err != nil {
+                    fmt.Fprintln(errOut, "ping: cannot reopen outfile:", err)
+                }
+            }
+        }()
+
+        // Files are unbuffered, so every line reaches the file as it is written
+        if *outfileOnly {
+            out = f
+            errOut = f
+        } else {
+            out = io.MultiWriter(os.Stdout, f)
+            errOut = io.MultiWriter(os.Stderr, f)
+        }
+    }
+
+    if *serveAddr != "" {
+        if err := checkRawSocket(false); err != nil {
+            fatal(err)
+        }
+        fmt.Fprintf(errOut, "Serving GET /ping?host=X&count=N on %v\n", *serveAddr)
+        err := serve(*serveAddr, size)
+        fatal(err)
+    }
+
+    host := flag.Args()[0]
+
+    if *happyEyeballs {
+        if *source != "" || opts.family != 0 {
+            usageError("happy-eyeballs cannot be combined with -I, -4 or -6")
+        }
+
+        ips := []net.IP{net.ParseIP(host)}
+        if ips[0] == nil {
+            var err error
+            ips, err = lookup(host, resolveTimeout)
+            if err == errResolveTimeout {
+                fmt.Fprintln(errOut, "ping:", err)
+                os.Exit(exitResolveTimeout)
+            } else if err != nil {
+                fatal("unknown host")
+            }
+        }
+        raceAddresses(host, ips, size)
+        return
+    }
+    ip, addrs, isIPv6, err := resolve(host, resolveTimeout)
+    var famErr familyError
+    if err == errResolveTimeout {
+        fmt.Fprintln(errOut, "ping:", err)
+        os.Exit(exitResolveTimeout)
+    } else if errors.As(err, &famErr) {
+        fatal(err)
+    } else if err != nil {
+        fatal("unknown host")
+    }
+
+    if *showAddrs {
+        showAddresses(host, addrs, ip)
+    }
+
+    // Both hosts are pinged from the same source, so of the same family
+    var otherIP net.IP
+    if *compareHost != "" {
+        var otherIsIPv6 bool
+        var otherAddrs []net.IP
+        otherIP, otherAddrs, otherIsIPv6, err = resolve(*compareHost, resolveTimeout)
+        if err == errResolveTimeout {
+            fmt.Fprintln(errOut, "ping:", err)
+            os.Exit(exitResolveTimeout)
+        } else if errors.As(err, &famErr) {
+            fatal(err)
+        } else if err != nil {
+            fatal("unknown host", *compareHost)
+        } else if otherIsIPv6 != isIPv6 {
+            usageError("compare requires hosts of the same family")
+        }
+        if *showAddrs {
+            showAddresses(*compareHost, otherAddrs, otherIP)
+        }
+    }
+
+    if *source != "" {
+        opts.source, err = sourceAddr(*source, isIPv6)
+        if err != nil {
+            fatal(err)
+        }
+    }
+
+    if *autoSource {
+        if *source != "" || *nextHop != "" || *rotateSource {
+            usageError("interface-source-auto cannot be combined " +
+                "with -I, gateway or rotate-source")
+        }
+        opts.source, err = routeSource(ip)
+        if err != nil {
+            fatal("cannot determine source address:", err)
+        }
+        fmt.Fprintf(errOut, "Using source %v for %v\n", opts.source, ip)
+    }
+
+    if *nextHop != "" {
+        if *source != "" || *rotateSource {
+            usageError("gateway cannot be combined with -I or rotate-source")
+        }
+        gw := net.ParseIP(*nextHop)
+        if gw == nil {
+            usageError("gateway must be an IP address")
+        } else if (gw.To4() == nil) != isIPv6 {
+            usageError("gateway and host must be of the same family")
+        }
+        iface, local, err := gatewayInterface(gw)
+        if err != nil {
+            fatal(err)
+        }
+        opts.device = iface.Name
+        opts.source = local
+    }
+
+    if *rotateSource {
+        if *source != "" {
+            usageError("rotate-source cannot be combined with -I")
+        }
+        opts.rotateSources, err = rotationSources(ip, isIPv6)
+        if err != nil {
+            fatal(err)
+        }
+    }
+
+    if opts.extHeader != "" {
+        if opts.extHeader != "hbh" && opts.extHeader != "dst" {
+            usageError("ext-header must be hbh or dst")
+        }
+        if !isIPv6 {
+            usageError("ext-header requires an IPv6 host")
+        }
+    }
+
+    if opts.validateChecksum && isIPv6 {
+        usageError("validate-checksum requires an IPv4 host, " +
+            "the kernel already drops ICMPv6 packets with bad checksums")
+    }
+
+    if opts.tsOption && isIPv6 {
+        usageError("ts-option requires an IPv4 host")
+    }
+
+    if *spoofSource != "" {
+        if !*spoofConfirmed {
+            usageError("spoof-source sends packets with a forged " +
+                "source address, confirm with --i-know-this-is-spoofing")
+        }
+        opts.spoofSource = net.ParseIP(*spoofSource).To4()
+        if opts.spoofSource == nil || isIPv6 {
+            usageError("spoof-source requires an IPv4 source and host")
+        }
+        if opts.tsOption {
+            usageError("spoof-source cannot be combined with ts-option")
+        }
+        fmt.Fprintf(errOut, "WARNING: sending from spoofed source %v, " +
+            "replies go to it and not to this host\n", opts.spoofSource)
+        opts.headerInclude = true
+    }
+
+    if *ipID != "" {
+        opts.ipID, err = parseIPID(*ipID)
+        if err != nil {
+            usageError(err)
+        } else if isIPv6 {
+            usageError("ip-id requires an IPv4 host, IPv6 has no ID field")
+        } else if opts.tsOption {
+            usageError("ip-id cannot be combined with ts-option")
+        }
+        opts.headerInclude = true
+    }
+
+    if *guessMTU && (opts.ndjson || opts.headerInclude) {
+        usageError("path-mtu cannot be combined with ndjson, " +
+            "spoof-source or ip-id")
+    }
+
+    // The TTL is set in our own header, not per probe
+    if opts.headerInclude && *traceroute {
+        usageError("traceroute cannot be combined with " +
+            "spoof-source or ip-id")
+    }
+
+    mtuSource := *source
+    if opts.device != "" {
+        mtuSource = opts.device
+    }
+    if *autoSize {
+        mtu, err := interfaceMTU(mtuSource, ip)
+        headers := ipv4.HeaderLen + 8
+        if isIPv6 {
+            headers = ipv6.HeaderLen + 8
+        }
+
+        if err != nil {
+            fmt.Fprintf(errOut, "ping: cannot determine MTU, using %v data bytes: %v\n", size, err)
+        } else if mtu - headers < paddingStart() {
+            fmt.Fprintf(errOut, "ping: MTU %v is too small, using %v data bytes\n", mtu, size)
+        } else {
+            size = mtu - headers
+            if size > maxDataSize {
+                size = maxDataSize
+            }
+        }
+    }
+
+    sigCh := make(chan os.Signal, 1)
+    done := make(chan bool)
+    signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+    // A nil channel never fires when there is no deadline
+    var deadlineCh <-chan time.Time
+    if deadline > 0 {
+        deadlineCh = time.After(deadline)
+    }
+
+    go func() {
+       select {
+       case <-sigCh:
+       case <-deadlineCh:
+       }
+       close(done)
+    }()
+
+    if err := checkRawSocket(isIPv6); err != nil {
+        fatal(err)
+    }
+
+    if *gateway {
+        checkGateway(isIPv6, size)
+    }
+
+    s := statsData{
+        trans: 0,
+        recv: 0,
+        rtts: make([]float64, 0),
+        start: time.Now(),
+    }
+
+    // Print a snapshot of the statistics so far on SIGQUIT (Ctrl-\)
+    quitCh := make(chan os.Signal, 1)
+    signal.Notify(quitCh, syscall.SIGQUIT)
+    go func() {
+        for range quitCh {
+            s.mu.Lock()
+            snapshot := &s
+            if s.round != nil {
+                snapshot = withRound(&s)
+            }
+            if opts.ndjson {
+                ndjsonStats(snapshot, host, ip.String())
+            } else if *summaryJSONOnSignal {
+                jsonStats(snapshot, host, ip.String())
+            } else {
+                stats(snapshot, "Snapshot")
+            }
+            if *resetOnReport {
+                resetStats(&s)
+                if s.round != nil {
+                    s.round.mu.Lock()
+                    resetStats(s.round)
+                    s.round.mu.Unlock()
+                }
+            }
+            s.mu.Unlock()
+        }
+    }()
+
+    // Pause sending on SIGUSR1 and resume on SIGUSR2, keeping the statistics
+    notifyPause()
+
+    if *oneWayMode {
+        fmt.Fprintf(out, "LISTEN %v (%v): one-way delay assumes synchronized clocks\n",
+            host, ip.String())
+        oneWay(ip, isIPv6, done)
+        return
+    }
+
+    if *traceroute {
+        fmt.Fprintf(out, "TRACEROUTE %v (%v): %v hops max, %v data bytes\n",
+            host, ip.String(), *maxHops, size)
+        trace(ip.String(), isIPv6, size, *maxHops, *probes, done)
+        return
+    }
+
+    if *minRTTOnly {
+        samples := *count
+        if samples <= 0 {
+            samples = minRTTSamples
+        }
+
+        // As fast as allowed, unless -i says otherwise
+        intervalSet := false
+        flag.Visit(func(f *flag.Flag) {
+            intervalSet = intervalSet || f.Name == "i"
+        })
+        if !intervalSet {
+            opts.interval = minInterval
+        }
+        minRTT(ip.String(), isIPv6, size, samples, done)
+        return
+    }
+
+    if *compareHost != "" {
+        fmt.Fprintf(out, "COMPARE %v (%v) with %v (%v): %v data bytes\n",
+            host, ip, *compareHost, otherIP, size)
+        compare([2]string{host, *compareHost}, [2]net.IP{ip, otherIP}, size, *count, done)
+        return
+    }
+
+    if *sweep != "" {
+        fmt.Fprintf(out, "PING %v (%v): %v to %v data bytes\n",
+            host, ip.String(), sweepStart, sweepEnd)
+        sizeSweep(ip.String(), isIPv6, sweepStart, sweepEnd, sweepStep, done)
+        return
+    }
+
+    if !opts.ndjson {
+        fmt.Fprintf(out, "PING %v (%v): %v data bytes\n", host, ip.String(), size)
+        if *guessMTU {
+            printPathMTU(ip.String(), isIPv6, mtuSource)
+        }
+        if opts.table {
+            tableHeader(isIPv6)
+        }
+    }
+    if *count != 0 {
+        if (*count < 0) {
+            usageError("count must be a positive number")
+        }
+
+        // A run that got no reply at all is repeated afresh, so a brief
+        // outage at startup does not fail it
+        for attempt := 1; ; attempt++ {
+            if *rounds > 1 {
+                pingRounds(ip.String(), isIPv6, size, *count, *rounds, gap,
+                    !*noStats && !*logfmt && !opts.ndjson, &s, done)
+            } else {
+                pingForTimes(ip.String(), isIPv6, size, *count, &s, done)
+            }
+            if s.recv > 0 || attempt > *runRetries || stopped(done) {
+                break
+            }
+
+            fmt.Fprintf(errOut, "Attempt %v of %v got no reply, retrying in %v\n",
+                attempt, *runRetries + 1, retryDelay)
+            select {
+            case <-done:
+            case <-time.After(retryDelay):
+            }
+            if stopped(done) {
+                break
+            }
+            s.mu.Lock()
+            resetStats(&s)
+            s.lossRun = 0
+            s.mu.Unlock()
         }
-        pingForTimes(ip.String(), isIPv6, size, *count, &s, done)
     } else {
         pingForever(ip.String(), isIPv6, size, &s, done)
     }
-    stats(&s)
+    if !*noStats {
+        if opts.ndjson {
+            ndjsonStats(&s, host, ip.String())
+        } else if *logfmt {
+            logfmtStats(&s, host, ip.String())
+        } else {
+            stats(&s, "Statistics")
+        }
+        silenceNote(&s, host)
+    }
+
+    if events != nil {
+        events.Close()
+    }
+
+    if *textfile != "" {
+        if err := writeTextfile(*textfile, &s, host, ip.String()); err != nil {
+            fmt.Fprintln(errOut, "ping: cannot write textfile:", err)
+        }
+    }
+
+    if *webhook != "" {
+        postSummary(*webhook, &s, host, ip.String())
+    }
+
+    if opts.stopOnLoss && s.recv < s.trans || *runRetries > 0 && s.recv == 0 ||
+        opts.upAfter > 0 && s.successRun < opts.upAfter {
+        os.Exit(exitPacketLoss)
+    }
+    if *slaMin > 0 && slaPercent(&s) < *slaMin {
+        os.Exit(exitSLAMissed)
+    }
 }
@@ -0,0 +1,1089 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "math/rand"
+    "net"
+    "os"
+    "strings"
+    "sync"
+    "syscall"
+    "testing"
+    "time"
+
+    "golang.org/x/net/icmp"
+    "golang.org/x/net/ipv4"
+    "golang.org/x/net/ipv6"
+)
+
+// Set the options to their defaults for the test and silence its output,
+// restoring both when it ends
+func setUp(t *testing.T) {
+    savedOpts, savedOut, savedErrOut := opts, out, errOut
+    savedDial, savedLookup := dial, lookup
+    t.Cleanup(func() {
+        opts, out, errOut = savedOpts, savedOut, savedErrOut
+        dial, lookup = savedDial, savedLookup
+    })
+
+    opts = options{
+        precision: 3,
+        interval: time.Millisecond,
+        timeout: 50 * time.Millisecond,
+        icmpType: -1,
+        burst: 1,
+        selectAddr: "first",
+        unit: "ms",
+    }
+    out, errOut = io.Discard, io.Discard
+}
+
+// A packet as a raw socket reads it: an ICMP message from an address, with
+// the TTL or hop limit it arrived with
+type fakePacket struct {
+    from net.IP
+    ttl int
+    msg icmp.Message
+}
+
+// A packetConn that hands out canned packets in answer to the echo requests
+// written to it, each after the delay, and times out once they run out
+type fakeConn struct {
+    isIPv6 bool
+    reply func(request []byte) []fakePacket
+    writeErr error
+    readErr error
+    packets []fakePacket
+    deadline time.Time
+    writes int
+    delay time.Duration
+}
+
+func (c *fakeConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+    c.writes++
+    if c.writeErr != nil {
+        return 0, c.writeErr
+    }
+    if c.reply != nil {
+        c.packets = append(c.packets, c.reply(b)...)
+    }
+    return len(b), nil
+}
+
+func (c *fakeConn) ReadMsgIP(b []byte, oob []byte) (n int, oobn int, flags int,
+    addr *net.IPAddr, err error) {
+    if c.readErr != nil {
+        err = c.readErr
+        return
+    }
+    if len(c.packets) == 0 {
+        time.Sleep(time.Until(c.deadline))
+        err = &net.OpError{Op: "read", Net: "ip", Err: os.ErrDeadlineExceeded}
+        return
+    }
+
+    time.Sleep(c.delay)
+    p := c.packets[0]
+    c.packets = c.packets[1:]
+    data, err := p.msg.Marshal(nil)
+    if err != nil {
+        return
+    }
+    addr = &net.IPAddr{IP: p.from}
+
+    // Raw ICMPv6 sockets get the hop limit in a control message, and raw
+    // IPv4 ones the whole packet with its header
+    if c.isIPv6 {
+        n = copy(b, data)
+        oobn = copy(oob, (&ipv6.ControlMessage{HopLimit: p.ttl}).Marshal())
+        return
+    }
+    header := ipv4.Header{
+        Version: ipv4.Version,
+        Len: ipv4.HeaderLen,
+        TotalLen: ipv4.HeaderLen + len(data),
+        TTL: p.ttl,
+        Protocol: 1,
+        Src: p.from,
+        Dst: net.IPv4(192, 0, 2, 100),
+    }
+    raw, err := header.Marshal()
+    if err != nil {
+        return
+    }
+    n = copy(b, append(raw, data...))
+    return
+}
+
+func (c *fakeConn) SetReadDeadline(t time.Time) error {
+    c.deadline = t
+    return nil
+}
+
+func (c *fakeConn) Close() error {
+    return nil
+}
+
+// Turn an echo request into the echo reply a host sends back
+func echoReplyTo(request []byte, isIPv6 bool) icmp.Message {
+    if isIPv6 {
+        m, _ := icmp.ParseMessage(58, request)
+        return icmp.Message{Type: ipv6.ICMPTypeEchoReply, Body: m.Body}
+    }
+    m, _ := icmp.ParseMessage(1, request)
+    return icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: m.Body}
+}
+
+// Quote an echo request as an ICMP error does, after the IP header it was
+// sent with
+func quote(request []byte, isIPv6 bool) []byte {
+    if isIPv6 {
+        return append(make([]byte, ipv6.HeaderLen), request...)
+    }
+    header := ipv4.Header{
+        Version: ipv4.Version,
+        Len: ipv4.HeaderLen,
+        TotalLen: ipv4.HeaderLen + len(request),
+        TTL: 1,
+        Protocol: 1,
+        Dst: net.IPv4(192, 0, 2, 7),
+    }
+    raw, _ := header.Marshal()
+    return append(raw, request...)
+}
+
+// Change a byte of an echo message at the offset from its start
+func changed(request []byte, offset int) []byte {
+    message := append([]byte(nil), request...)
+    message[offset]++
+    return message
+}
+
+func TestExchange(t *testing.T) {
+    dst := net.IPv4(192, 0, 2, 7)
+    router := net.IPv4(198, 51, 100, 1)
+    tests := []struct {
+        name string
+        reply func(request []byte) []fakePacket
+        writeErr error
+        readErr error
+        wantErr error
+        wantFrom net.IP
+        wantTTL int
+    }{
+        {
+            name: "reply",
+            reply: func(request []byte) []fakePacket {
+                return []fakePacket{{dst, 57, echoReplyTo(request, false)}}
+            },
+            wantFrom: dst,
+            wantTTL: 57,
+        },
+        {
+            name: "stale reply skipped",
+            reply: func(request []byte) []fakePacket {
+                return []fakePacket{
+                    {dst, 57, echoReplyTo(changed(request, 8), false)},
+                    {dst, 58, echoReplyTo(request, false)},
+                }
+            },
+            wantFrom: dst,
+            wantTTL: 58,
+        },
+        {
+            name: "only a stale reply",
+            reply: func(request []byte) []fakePacket {
+                return []fakePacket{{dst, 57, echoReplyTo(changed(request, 8), false)}}
+            },
+            wantErr: os.ErrDeadlineExceeded,
+        },
+        {
+            name: "no reply",
+            wantErr: os.ErrDeadlineExceeded,
+        },
+        {
+            name: "time exceeded",
+            reply: func(request []byte) []fakePacket {
+                return []fakePacket{{router, 250, icmp.Message{
+                    Type: ipv4.ICMPTypeTimeExceeded,
+                    Body: &icmp.TimeExceeded{Data: quote(request, false)},
+                }}}
+            },
+            wantErr: errTimeExceeded,
+            wantFrom: router,
+        },
+        {
+            name: "time exceeded for another echo request",
+            reply: func(request []byte) []fakePacket {
+                return []fakePacket{{router, 250, icmp.Message{
+                    Type: ipv4.ICMPTypeTimeExceeded,
+                    Body: &icmp.TimeExceeded{Data: quote(changed(request, 7), false)},
+                }}}
+            },
+            wantErr: os.ErrDeadlineExceeded,
+        },
+        {
+            name: "unreachable",
+            reply: func(request []byte) []fakePacket {
+                return []fakePacket{{router, 250, icmp.Message{
+                    Type: ipv4.ICMPTypeDestinationUnreachable,
+                    Code: 1,
+                    Body: &icmp.DstUnreach{Data: quote(request, false)},
+                }}}
+            },
+            wantErr: errUnreachable,
+            wantFrom: router,
+        },
+        {
+            name: "fragmentation needed",
+            reply: func(request []byte) []fakePacket {
+                return []fakePacket{{router, 250, icmp.Message{
+                    Type: ipv4.ICMPTypeDestinationUnreachable,
+                    Code: fragmentationNeeded,
+                    Body: &icmp.DstUnreach{Data: quote(request, false)},
+                }}}
+            },
+            wantErr: errTooBig,
+            wantFrom: router,
+        },
+        {
+            name: "network unreachable on send",
+            writeErr: syscall.ENETUNREACH,
+            wantErr: errNetUnreachable,
+        },
+        {
+            name: "too big to send",
+            writeErr: syscall.EMSGSIZE,
+            wantErr: errTooBig,
+        },
+        {
+            name: "send error",
+            writeErr: syscall.EPERM,
+            wantErr: syscall.EPERM,
+        },
+        {
+            name: "read error",
+            readErr: syscall.ECONNREFUSED,
+            wantErr: syscall.ECONNREFUSED,
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            setUp(t)
+            c := &fakeConn{reply: test.reply, writeErr: test.writeErr, readErr: test.readErr}
+            start := time.Now()
+            reply, err := exchange(c, 3, &net.IPAddr{IP: dst}, false, 56)
+            waited := float64(time.Since(start)) / float64(time.Millisecond)
+
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("got error %v, want %v", err, test.wantErr)
+            }
+            if test.wantFrom != nil && !reply.from.Equal(test.wantFrom) {
+                t.Errorf("got reply from %v, want %v", reply.from, test.wantFrom)
+            }
+            if test.wantTTL != 0 && reply.ttl != test.wantTTL {
+                t.Errorf("got TTL %v, want %v", reply.ttl, test.wantTTL)
+            }
+            if err == nil && (reply.rtt < 0 || reply.rtt > waited) {
+                t.Errorf("got RTT %v ms after waiting %v ms", reply.rtt, waited)
+            }
+            if err == nil && (reply.corrupted || reply.otherSource || reply.idRewritten) {
+                t.Errorf("got reply flagged %+v", reply)
+            }
+        })
+    }
+}
+
+// Make every echo request get an immediate reply from the host
+func dialReplying(host net.IP) {
+    dial = func(network string, isIPv6 bool, ttl int) (packetConn, error) {
+        return &fakeConn{
+            isIPv6: isIPv6,
+            reply: func(request []byte) []fakePacket {
+                return []fakePacket{{host, 64, echoReplyTo(request, isIPv6)}}
+            },
+        }, nil
+    }
+}
+
+func TestCountAndDeadline(t *testing.T) {
+    tests := []struct {
+        name string
+        count int
+        deadline time.Duration
+        interrupt time.Duration
+        wantTrans int
+    }{
+        {"count only", 3, 0, 0, 3},
+        {"deadline only", 0, 100 * time.Millisecond, 0, 0},
+        {"count reached first", 3, time.Second, 0, 3},
+        {"deadline reached first", 1000, 100 * time.Millisecond, 0, 0},
+        {"count and deadline together", 6, 250 * time.Millisecond, 0, 6},
+        {"neither reached before interrupt", 1000, time.Second,
+            100 * time.Millisecond, 0},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            setUp(t)
+            opts.interval = 20 * time.Millisecond
+            host := net.IPv4(192, 0, 2, 7)
+            dialReplying(host)
+
+            // As in main, the deadline or an interrupt closes done
+            done := make(chan bool)
+            var deadlineCh, sigCh <-chan time.Time
+            if test.deadline > 0 {
+                deadlineCh = time.After(test.deadline)
+            }
+            if test.interrupt > 0 {
+                sigCh = time.After(test.interrupt)
+            }
+            stop := test.deadline
+            if test.interrupt > 0 {
+                stop = test.interrupt
+            }
+            if stop > 0 {
+                go func() {
+                    select {
+                    case <-sigCh:
+                    case <-deadlineCh:
+                    }
+                    close(done)
+                }()
+            }
+
+            s := statsData{rtts: make([]float64, 0), start: time.Now()}
+            if test.count > 0 {
+                pingForTimes(host.String(), false, 56, test.count, &s, done)
+            } else {
+                pingForever(host.String(), false, 56, &s, done)
+            }
+            took := time.Since(s.start)
+
+            if s.recv != s.trans {
+                t.Errorf("got %v replies to %v echo requests", s.recv, s.trans)
+            }
+            if test.wantTrans > 0 && s.trans != test.wantTrans {
+                t.Errorf("sent %v echo requests, want %v", s.trans, test.wantTrans)
+            }
+            if test.wantTrans == 0 {
+                // Stopped by the deadline or the interrupt, one echo request
+                // per interval
+                if s.trans == 0 || test.count > 0 && s.trans >= test.count {
+                    t.Errorf("sent %v echo requests before stopping", s.trans)
+                }
+                if took > stop + 10 * opts.interval {
+                    t.Errorf("stopped %v after being told to at %v", took, stop)
+                }
+            }
+        })
+    }
+}
+
+func TestRoundsStop(t *testing.T) {
+    tests := []struct {
+        name string
+        upAfter int
+        stopAfter int
+        wantTrans int
+    }{
+        {"all rounds", 0, 0, 9},
+        {"up within the first round", 2, 0, 2},
+        {"up across rounds", 5, 0, 5},
+        {"received within the first round", 0, 2, 2},
+        {"received across rounds", 0, 4, 4},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            setUp(t)
+            opts.upAfter = test.upAfter
+            opts.stopAfter = test.stopAfter
+            host := net.IPv4(192, 0, 2, 7)
+            dialReplying(host)
+
+            s := statsData{rtts: make([]float64, 0), start: time.Now()}
+            pingRounds(host.String(), false, 56, 3, 3, time.Millisecond, false, &s,
+                make(chan bool))
+
+            if s.trans != test.wantTrans {
+                t.Errorf("sent %v echo requests, want %v", s.trans, test.wantTrans)
+            }
+            // main exits with exitPacketLoss unless the run is up
+            if test.upAfter > 0 && s.successRun < test.upAfter {
+                t.Errorf("got %v replies in a row, want %v", s.successRun, test.upAfter)
+            }
+        })
+    }
+}
+
+func TestRoundsLost(t *testing.T) {
+    setUp(t)
+    opts.timeout = 10 * time.Millisecond
+    host := net.IPv4(192, 0, 2, 7)
+
+    // The second echo request of every round goes unanswered
+    sent := 0
+    dial = func(network string, isIPv6 bool, ttl int) (packetConn, error) {
+        sent++
+        lost := sent % 3 == 2
+        return &fakeConn{
+            reply: func(request []byte) []fakePacket {
+                if lost {
+                    return nil
+                }
+                return []fakePacket{{host, 64, echoReplyTo(request, false)}}
+            },
+        }, nil
+    }
+
+    s := statsData{rtts: make([]float64, 0), start: time.Now()}
+    pingRounds(host.String(), false, 56, 3, 3, time.Millisecond, false, &s,
+        make(chan bool))
+
+    if got, want := formatGaps(s.lost), "1, 4, 7"; got != want {
+        t.Errorf("missing %v, want %v", got, want)
+    }
+}
+
+func TestMergeBuckets(t *testing.T) {
+    setUp(t)
+    opts.availability = time.Minute
+    start := time.Now()
+    s := statsData{start: start}
+
+    rounds := []statsData{
+        {start: start, buckets: []bucket{{1, 0}}},
+        {start: start.Add(90 * time.Second), buckets: []bucket{{2, 2}, {3, 1}}},
+        {start: start.Add(150 * time.Second), buckets: []bucket{{4, 4}}},
+    }
+    for i := range rounds {
+        mergeBuckets(&s, &rounds[i])
+    }
+
+    want := []bucket{{1, 0}, {2, 2}, {7, 5}}
+    if len(s.buckets) != len(want) {
+        t.Fatalf("got windows %v, want %v", s.buckets, want)
+    }
+    for i := range want {
+        if s.buckets[i] != want[i] {
+            t.Errorf("got windows %v, want %v", s.buckets, want)
+            break
+        }
+    }
+}
+
+func TestResetStatsKeepsSRTT(t *testing.T) {
+    s := statsData{rtts: make([]float64, 0), start: time.Now()}
+    for _, rtt := range []float64{8, 8} {
+        s.rtts = append(s.rtts, rtt)
+        updateSRTT(&s, rtt)
+    }
+    resetStats(&s)
+
+    s.rtts = append(s.rtts, 16)
+    updateSRTT(&s, 16)
+    if s.srtt != 9 || s.rttvar != 4.25 {
+        t.Errorf("got srtt %v rttvar %v after a report, want 9 and 4.25", s.srtt, s.rttvar)
+    }
+}
+
+func TestPrintReplyReverseDNS(t *testing.T) {
+    tests := []struct {
+        name string
+        table bool
+        want string
+    }{
+        {"line", false, "(reply from other.example (192.0.2.8), expected " +
+            "target.example (192.0.2.7))"},
+        {"table", true, "other.example (192.0.2.8)"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            setUp(t)
+            opts.reverseDNS = true
+            opts.table = test.table
+            reverseNames.Lock()
+            reverseNames.names["192.0.2.7"] = "target.example"
+            reverseNames.names["192.0.2.8"] = "other.example"
+            reverseNames.Unlock()
+            t.Cleanup(func() {
+                reverseNames.Lock()
+                delete(reverseNames.names, "192.0.2.7")
+                delete(reverseNames.names, "192.0.2.8")
+                reverseNames.Unlock()
+            })
+            var b strings.Builder
+            out = &b
+
+            reply := echoReply{from: net.IPv4(192, 0, 2, 8), ttl: 64, otherSource: true}
+            s := statsData{rtts: make([]float64, 0), start: time.Now()}
+            printReply("192.0.2.7", 0, false, reply, &s)
+
+            if !strings.Contains(b.String(), test.want) {
+                t.Errorf("got %q, want it to contain %q", b.String(), test.want)
+            }
+        })
+    }
+}
+
+// A packetWriter whose first writes fail with the given error
+type failingWriter struct {
+    failures int
+    err error
+    writes int
+}
+
+func (w *failingWriter) WriteTo(b []byte, addr net.Addr) (int, error) {
+    w.writes++
+    if w.writes <= w.failures {
+        return 0, &os.SyscallError{Syscall: "sendto", Err: w.err}
+    }
+    return len(b), nil
+}
+
+func TestWriteRetry(t *testing.T) {
+    tests := []struct {
+        name string
+        failures int
+        err error
+        wantWrites int
+        wantErr error
+    }{
+        {"first write", 0, syscall.ENOBUFS, 1, nil},
+        {"retry then success", 1, syscall.ENOBUFS, 2, nil},
+        {"last retry succeeds", writeRetries, syscall.ENOBUFS, writeRetries + 1, nil},
+        {"retries exhausted", writeRetries + 1, syscall.ENOBUFS, writeRetries + 1,
+            syscall.ENOBUFS},
+        {"other errors not retried", 1, syscall.EPERM, 1, syscall.EPERM},
+    }
+
+    dst := &net.IPAddr{IP: net.IPv4(192, 0, 2, 7)}
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            w := &failingWriter{failures: test.failures, err: test.err}
+            err := writeRetry(w, []byte{8, 0, 0, 0}, dst)
+            if !errors.Is(err, test.wantErr) {
+                t.Errorf("got error %v, want %v", err, test.wantErr)
+            }
+            if w.writes != test.wantWrites {
+                t.Errorf("wrote %v times, want %v", w.writes, test.wantWrites)
+            }
+        })
+    }
+}
+
+func TestEchoSizes(t *testing.T) {
+    tests := []struct {
+        name string
+        size int
+        nonce bool
+        payload []byte
+        wantErr bool
+    }{
+        {"empty", 0, false, nil, true},
+        {"shorter than the send time", 7, false, nil, true},
+        {"send time only", 8, false, nil, true},
+        {"no room for the sequence", 11, false, nil, true},
+        {"send time and sequence", 12, false, nil, false},
+        {"default", 56, false, nil, false},
+        {"largest", maxDataSize, false, nil, false},
+        {"too large", maxDataSize + 1, false, nil, true},
+        {"no room for the nonce", 12, true, nil, true},
+        {"with nonce", 20, true, nil, false},
+        {"empty from payload file", 0, false, []byte("abc"), false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            setUp(t)
+            opts.nonce = test.nonce
+            opts.payload = test.payload
+            data, err := echo(1, false, test.size)
+            if (err != nil) != test.wantErr {
+                t.Fatalf("got error %v for %v bytes", err, test.size)
+            }
+            if err == nil && len(data) != 8 + test.size {
+                t.Errorf("got a %v-byte message for %v bytes", len(data), test.size)
+            }
+        })
+    }
+}
+
+func TestExchangeMarshalFailure(t *testing.T) {
+    setUp(t)
+    dst := net.IPv4(192, 0, 2, 7)
+    c := &fakeConn{
+        reply: func(request []byte) []fakePacket {
+            return []fakePacket{{dst, 64, echoReplyTo(request, false)}}
+        },
+    }
+
+    // Too short for the send time and sequence, so echo cannot build it
+    _, err := exchange(c, 1, &net.IPAddr{IP: dst}, false, 7)
+    if err == nil {
+        t.Fatal("got no error for an echo request that cannot be built")
+    }
+    if c.writes != 0 {
+        t.Errorf("sent %v packets after failing to build the echo request", c.writes)
+    }
+}
+
+// Make every host resolve to the given addresses, or fail with err
+func fakeLookup(addrs []string, err error) {
+    lookup = func(host string, timeout time.Duration) ([]net.IP, error) {
+        if err != nil {
+            return nil, err
+        }
+        var ips []net.IP
+        for _, addr := range addrs {
+            ips = append(ips, net.ParseIP(addr))
+        }
+        return ips, nil
+    }
+}
+
+func TestResolveFamily(t *testing.T) {
+    v4Only := []string{"192.0.2.7", "192.0.2.8"}
+    v6Only := []string{"2001:db8::7"}
+    both := []string{"192.0.2.7", "2001:db8::7"}
+    tests := []struct {
+        name string
+        host string
+        addrs []string
+        lookupErr error
+        family int
+        wantIP string
+        wantIPv6 bool
+        wantAll int
+        wantErr string
+    }{
+        {"IPv4 host", "host", v4Only, nil, 0, "192.0.2.7", false, 2, ""},
+        {"IPv4 host with -4", "host", v4Only, nil, 4, "192.0.2.7", false, 2, ""},
+        {"IPv4 host with -6", "host", v4Only, nil, 6, "", false, 0,
+            "-6 requested but host has no AAAA record"},
+        {"IPv6 host", "host", v6Only, nil, 0, "2001:db8::7", true, 1, ""},
+        {"IPv6 host with -4", "host", v6Only, nil, 4, "", false, 0,
+            "-4 requested but host has no A record"},
+        {"IPv6 host with -6", "host", v6Only, nil, 6, "2001:db8::7", true, 1, ""},
+        {"both families with -6", "host", both, nil, 6, "2001:db8::7", true, 2, ""},
+        {"IPv4 address with -6", "192.0.2.9", nil, nil, 6, "", false, 0,
+            "-6 requested but 192.0.2.9 is not an IPv6 address"},
+        {"lookup failure", "host", nil, errResolveTimeout, 0, "", false, 0,
+            errResolveTimeout.Error()},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            setUp(t)
+            opts.family = test.family
+            fakeLookup(test.addrs, test.lookupErr)
+
+            ip, all, isIPv6, err := resolve(test.host, 0)
+            if test.wantErr != "" {
+                if err == nil || err.Error() != test.wantErr {
+                    t.Fatalf("got error %v, want %v", err, test.wantErr)
+                }
+                var famErr familyError
+                if test.lookupErr == nil && !errors.As(err, &famErr) {
+                    t.Errorf("got %T, want a familyError", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal("got error", err)
+            }
+            if !ip.Equal(net.ParseIP(test.wantIP)) || isIPv6 != test.wantIPv6 {
+                t.Errorf("got %v (IPv6 %v), want %v (IPv6 %v)", ip, isIPv6,
+                    test.wantIP, test.wantIPv6)
+            }
+            if len(all) != test.wantAll {
+                t.Errorf("got %v addresses, want %v", len(all), test.wantAll)
+            }
+        })
+    }
+}
+
+func TestSelectAddress(t *testing.T) {
+    addrs := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"}
+    const seed = 42
+    seeded := addrs[rand.New(rand.NewSource(seed)).Intn(len(addrs))]
+    tests := []struct {
+        which string
+        seed int64
+        want string
+        wantErr bool
+    }{
+        {"first", 0, addrs[0], false},
+        {"last", 0, addrs[3], false},
+        {"random", seed, seeded, false},
+        {"all", 0, "", true},
+        {"middle", 0, "", true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.which, func(t *testing.T) {
+            setUp(t)
+            fakeLookup(addrs, nil)
+            var err error
+            opts.selectAddr = test.which
+            opts.selectRand, err = parseSelect(test.which, test.seed)
+            if (err != nil) != test.wantErr {
+                t.Fatalf("got error %v for --select %v", err, test.which)
+            }
+            if err != nil {
+                return
+            }
+
+            ip, all, _, err := resolve("host", 0)
+            if err != nil {
+                t.Fatal("got error", err)
+            }
+            if !ip.Equal(net.ParseIP(test.want)) {
+                t.Errorf("selected %v, want %v", ip, test.want)
+            }
+            if len(all) != len(addrs) {
+                t.Errorf("got %v addresses, want %v", len(all), len(addrs))
+            }
+        })
+    }
+}
+
+func TestSelectRandomSeed(t *testing.T) {
+    setUp(t)
+    addrs := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"}
+    fakeLookup(addrs, nil)
+    opts.selectAddr = "random"
+
+    // The same seed picks the same addresses run after run
+    var picks [2][]string
+    for run := range picks {
+        opts.selectRand, _ = parseSelect("random", 7)
+        for i := 0; i < 20; i++ {
+            ip, _, _, err := resolve("host", 0)
+            if err != nil {
+                t.Fatal("got error", err)
+            }
+            picks[run] = append(picks[run], ip.String())
+        }
+    }
+
+    seen := make(map[string]bool)
+    for i := range picks[0] {
+        if picks[0][i] != picks[1][i] {
+            t.Fatalf("seed 7 picked %v then %v", picks[0], picks[1])
+        }
+        seen[picks[0][i]] = true
+    }
+    if len(seen) < 2 {
+        t.Errorf("20 random picks were all %v", picks[0][0])
+    }
+}
+
+// As --serve does, resolve hosts with random picks from several goroutines
+func TestSelectRandomConcurrent(t *testing.T) {
+    setUp(t)
+    fakeLookup([]string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, nil)
+    opts.selectAddr = "random"
+    opts.selectRand, _ = parseSelect("random", 7)
+
+    var wg sync.WaitGroup
+    for i := 0; i < 8; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 100; j++ {
+                if _, _, _, err := resolve("host", 0); err != nil {
+                    t.Error("got error", err)
+                    return
+                }
+            }
+        }()
+    }
+    wg.Wait()
+}
+
+func TestExchangePayloadFile(t *testing.T) {
+    dst := net.IPv4(192, 0, 2, 7)
+    const delay = 20 * time.Millisecond
+    tests := []struct {
+        name string
+        reply func(request []byte) []byte
+        size int
+        wantErr error
+        wantCorrupted bool
+    }{
+        {"reply", func(request []byte) []byte {
+            return request
+        }, 32, nil, false},
+        {"file larger than -s", func(request []byte) []byte {
+            return request
+        }, 8, nil, false},
+        {"changed payload", func(request []byte) []byte {
+            return changed(request, 9)
+        }, 32, nil, true},
+        {"another sequence", func(request []byte) []byte {
+            return changed(request, 7)
+        }, 32, os.ErrDeadlineExceeded, false},
+        {"another identifier", func(request []byte) []byte {
+            return changed(request, 5)
+        }, 32, os.ErrDeadlineExceeded, false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            setUp(t)
+            opts.payload = []byte("payload from a file")
+            c := &fakeConn{
+                delay: delay,
+                reply: func(request []byte) []fakePacket {
+                    return []fakePacket{{dst, 64, echoReplyTo(test.reply(request), false)}}
+                },
+            }
+            start := time.Now()
+            reply, err := exchange(c, 3, &net.IPAddr{IP: dst}, false, test.size)
+            waited := float64(time.Since(start)) / float64(time.Millisecond)
+
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("got error %v, want %v", err, test.wantErr)
+            }
+            if err != nil {
+                return
+            }
+
+            // Timed from sending, as the payload carries no send time
+            if reply.rtt < float64(delay) / float64(time.Millisecond) || reply.rtt > waited {
+                t.Errorf("got RTT %v ms for a reply after %v, waiting %v ms",
+                    reply.rtt, delay, waited)
+            }
+            if reply.corrupted != test.wantCorrupted {
+                t.Errorf("got corrupted %v, want %v", reply.corrupted, test.wantCorrupted)
+            }
+
+            // The file is cut or padded with zeros to -s bytes
+            n := len(opts.payload)
+            if n > test.size {
+                n = test.size
+            }
+            if len(reply.payload) != test.size ||
+                !test.wantCorrupted && !bytes.Equal(reply.payload[:n], opts.payload[:n]) {
+                t.Errorf("got payload %q of %v bytes, want %v bytes", reply.payload,
+                    len(reply.payload), test.size)
+            }
+        })
+    }
+}
+
+func TestExchangeRTT(t *testing.T) {
+    dst := net.IPv4(192, 0, 2, 7)
+    const delay = 20 * time.Millisecond
+    tests := []struct {
+        name string
+        reply func(request []byte) []byte
+        wantErr error
+    }{
+        {"reply", func(request []byte) []byte {
+            return request
+        }, nil},
+        // The top byte of the send time, which would be nonsense as a time
+        {"corrupt send time", func(request []byte) []byte {
+            return changed(request, 15)
+        }, os.ErrDeadlineExceeded},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            setUp(t)
+            c := &fakeConn{
+                delay: delay,
+                reply: func(request []byte) []fakePacket {
+                    return []fakePacket{{dst, 64, echoReplyTo(test.reply(request), false)}}
+                },
+            }
+            start := time.Now()
+            reply, err := exchange(c, 3, &net.IPAddr{IP: dst}, false, 56)
+            waited := float64(time.Since(start)) / float64(time.Millisecond)
+
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("got error %v, want %v", err, test.wantErr)
+            }
+            if err != nil {
+                return
+            }
+            if reply.rtt < float64(delay) / float64(time.Millisecond) || reply.rtt > waited {
+                t.Errorf("got RTT %v ms for a reply after %v, waiting %v ms",
+                    reply.rtt, delay, waited)
+            }
+        })
+    }
+}
+
+func TestSourceAddr(t *testing.T) {
+    tests := []struct {
+        name string
+        source string
+        isIPv6 bool
+        want string
+        wantErr bool
+    }{
+        {"IPv4 address", "192.0.2.1", false, "192.0.2.1", false},
+        {"IPv6 address", "2001:db8::1", true, "2001:db8::1", false},
+        {"IPv4 address for IPv6", "192.0.2.1", true, "", true},
+        {"IPv6 address for IPv4", "2001:db8::1", false, "", true},
+        {"unknown interface", "no-such-interface0", false, "", true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            ip, err := sourceAddr(test.source, test.isIPv6)
+            if (err != nil) != test.wantErr {
+                t.Fatalf("got error %v", err)
+            }
+            if err == nil && !ip.Equal(net.ParseIP(test.want)) {
+                t.Errorf("got %v, want %v", ip, test.want)
+            }
+        })
+    }
+}
+
+func TestSourceAddrInterface(t *testing.T) {
+    ifaces, err := net.Interfaces()
+    if err != nil {
+        t.Skip("cannot list interfaces:", err)
+    }
+    for _, iface := range ifaces {
+        if iface.Flags & net.FlagLoopback == 0 {
+            continue
+        }
+        addrs, err := iface.Addrs()
+        if err != nil {
+            t.Fatal("got error", err)
+        }
+
+        // An address of the interface of the family asked for, if it has one
+        for _, isIPv6 := range []bool{false, true} {
+            has := false
+            for _, addr := range addrs {
+                ipNet, ok := addr.(*net.IPNet)
+                has = has || ok && (ipNet.IP.To4() == nil) == isIPv6
+            }
+
+            ip, err := sourceAddr(iface.Name, isIPv6)
+            if !has {
+                if err == nil {
+                    t.Errorf("got %v from %v for IPv6 %v, which it lacks", ip,
+                        iface.Name, isIPv6)
+                }
+                continue
+            }
+            if err != nil {
+                t.Fatal("got error", err)
+            }
+            if (ip.To4() == nil) != isIPv6 {
+                t.Errorf("got %v from %v for IPv6 %v", ip, iface.Name, isIPv6)
+            }
+        }
+        return
+    }
+    t.Skip("no loopback interface")
+}
+
+func TestExchangePayloadSeq(t *testing.T) {
+    setUp(t)
+    dst := net.IPv4(192, 0, 2, 7)
+
+    // The reply to the next echo request of a burst has our ICMP identifier
+    // but the next sequence in its payload, so is not taken as ours
+    c := &fakeConn{
+        reply: func(request []byte) []fakePacket {
+            return []fakePacket{
+                {dst, 64, echoReplyTo(changed(request, 8 + timestampLen), false)},
+                {dst, 64, echoReplyTo(request, false)},
+            }
+        },
+    }
+    reply, err := exchange(c, 3, &net.IPAddr{IP: dst}, false, 56)
+    if err != nil {
+        t.Fatal("got error", err)
+    }
+    if len(reply.overtakenBy) != 1 || reply.overtakenBy[0] != 4 {
+        t.Errorf("got overtaken by %v, want [4]", reply.overtakenBy)
+    }
+
+    // With only that reply, ours times out
+    c = &fakeConn{
+        reply: func(request []byte) []fakePacket {
+            return []fakePacket{
+                {dst, 64, echoReplyTo(changed(request, 8 + timestampLen), false)},
+            }
+        },
+    }
+    _, err = exchange(c, 3, &net.IPAddr{IP: dst}, false, 56)
+    if !errors.Is(err, os.ErrDeadlineExceeded) {
+        t.Errorf("got error %v, want a timeout", err)
+    }
+}
+
+func TestCheckSize(t *testing.T) {
+    tests := []struct {
+        name string
+        size int
+        nonce bool
+        payload []byte
+        wantErr bool
+    }{
+        {"negative", -1, false, nil, true},
+        {"empty", 0, false, nil, true},
+        {"one short of the header", 11, false, nil, true},
+        {"header only", 12, false, nil, false},
+        {"default", 56, false, nil, false},
+        {"largest", maxDataSize, false, nil, false},
+        {"too large", maxDataSize + 1, false, nil, true},
+        {"one short of the nonce", 19, true, nil, true},
+        {"header and nonce", 20, true, nil, false},
+        {"empty from payload file", 0, false, []byte("abc"), false},
+        {"negative from payload file", -1, false, []byte("abc"), true},
+        {"too large from payload file", maxDataSize + 1, false, []byte("abc"), true},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            setUp(t)
+            opts.nonce = test.nonce
+            opts.payload = test.payload
+            if err := checkSize(test.size); (err != nil) != test.wantErr {
+                t.Errorf("got error %v for -s %v", err, test.size)
+            }
+        })
+    }
+}
+
+func TestExchangeIPv6HopLimit(t *testing.T) {
+    setUp(t)
+    dst := net.ParseIP("2001:db8::7")
+
+    // Raw ICMPv6 sockets see no IPv6 header, only the control message
+    for _, hopLimit := range []int{1, 42, 255} {
+        c := &fakeConn{
+            isIPv6: true,
+            reply: func(request []byte) []fakePacket {
+                return []fakePacket{{dst, hopLimit, echoReplyTo(request, true)}}
+            },
+        }
+        reply, err := exchange(c, 3, &net.IPAddr{IP: dst}, true, 56)
+        if err != nil {
+            t.Fatal("got error", err)
+        }
+        if reply.ttl != hopLimit {
+            t.Errorf("got hop limit %v, want %v", reply.ttl, hopLimit)
+        }
+        if !reply.from.Equal(dst) || reply.headerLen != ipv6.HeaderLen {
+            t.Errorf("got reply from %v with a %v-byte header", reply.from, reply.headerLen)
+        }
+    }
+}
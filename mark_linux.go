@@ -0,0 +1,23 @@
+package main
+
+import (
+    "net"
+    "syscall"
+)
+
+// Set the firewall mark of the packets sent on the connection, which policy
+// routing rules can match on to pick a routing table
+func setMark(c *net.IPConn, mark int) error {
+    raw, err := c.SyscallConn()
+    if err != nil {
+        return err
+    }
+    var sockErr error
+    err = raw.Control(func(fd uintptr) {
+        sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+    })
+    if err != nil {
+        return err
+    }
+    return sockErr
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+    "fmt"
+    "net"
+)
+
+// Set the firewall mark of the packets sent on the connection, which policy
+// routing rules can match on to pick a routing table
+func setMark(c *net.IPConn, mark int) error {
+    return fmt.Errorf("marks are only supported on Linux")
+}
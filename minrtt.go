@@ -0,0 +1,37 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "time"
+)
+
+// How many echo requests --min-rtt-only sends unless -c says otherwise
+const minRTTSamples = 20
+
+// Ping count times and print only the lowest round-trip time, the best
+// estimate of the propagation delay as it is the least held up by queues
+func minRTT(ip string, isIPv6 bool, dataSize int, count int, done chan bool) {
+    best := -1.0
+probes:
+    for seq := 0; seq < count; seq++ {
+        if seq > 0 {
+            select {
+            case <-done:
+                break probes
+            case <-time.After(opts.interval):
+            }
+        }
+
+        reply, err := pingOnce(seq, ip, isIPv6, dataSize, opts.ttl)
+        if err == nil && (best < 0 || reply.rtt < best) {
+            best = reply.rtt
+        }
+    }
+
+    if best < 0 {
+        fmt.Fprintln(errOut, "No reply received")
+        os.Exit(exitPacketLoss)
+    }
+    fmt.Fprintf(out, "%.*f\n", opts.precision, best)
+}
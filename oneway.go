@@ -0,0 +1,84 @@
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+    "net"
+    "time"
+
+    "golang.org/x/net/icmp"
+    "golang.org/x/net/ipv4"
+    "golang.org/x/net/ipv6"
+)
+
+// Listen for echo requests sent by this program on the peer and estimate the
+// one-way delay from the send timestamp in their payload. The estimate is
+// only as good as the synchronization of the two clocks, e.g. by NTP, and
+// any offset between them shows up directly in the delay
+func oneWay(peer net.IP, isIPv6 bool, done chan bool) {
+    network, address, proto := "ip4:icmp", "0.0.0.0", 1
+    if isIPv6 {
+        network, address, proto = "ip6:ipv6-icmp", "::", 58
+    }
+
+    c, err := icmp.ListenPacket(network, address)
+    if err != nil {
+        fatal("cannot listen for echo requests:", err)
+    }
+    go func() {
+        <-done
+        c.Close()
+    }()
+
+    delays := make([]float64, 0)
+    data := make([]byte, 65536)
+    for {
+        n, from, err := c.ReadFrom(data)
+        if err != nil {
+            break
+        }
+        received := time.Now()
+
+        if addr, ok := from.(*net.IPAddr); !ok || !addr.IP.Equal(peer) {
+            continue
+        }
+        msg, err := icmp.ParseMessage(proto, data[:n])
+        if err != nil ||
+            (msg.Type != ipv4.ICMPTypeEcho && msg.Type != ipv6.ICMPTypeEchoRequest) {
+            continue
+        }
+        body, ok := msg.Body.(*icmp.Echo)
+        if !ok || len(body.Data) < payloadHeaderLen {
+            continue
+        }
+
+        sent := int64(binary.LittleEndian.Uint64(body.Data[:timestampLen]))
+        seq := binary.LittleEndian.Uint32(body.Data[timestampLen:])
+        delay := float64(received.UnixNano() - sent) / 1000000.0
+        delays = append(delays, delay)
+        fmt.Fprintf(out, "Request from %v: icmp_seq=%v one-way delay=%.*f %v\n",
+            peer, formatSeq(int(seq)), opts.precision, inUnit(delay), opts.unit)
+    }
+
+    fmt.Fprintln(out, "\n--- One-way delay (clock-dependent) ---")
+    fmt.Fprintf(out, "%v echo requests received\n", len(delays))
+    if len(delays) == 0 {
+        return
+    }
+
+    delayMin, delayMax, delayAvg := delays[0], delays[0], 0.0
+    for _, d := range delays {
+        if d < delayMin {
+            delayMin = d
+        }
+        if d > delayMax {
+            delayMax = d
+        }
+        delayAvg += d
+    }
+    delayAvg /= float64(len(delays))
+
+    p := opts.precision
+    fmt.Fprintf(out, "one-way delay min/avg/max = %.*f/%.*f/%.*f %v\n",
+        p, inUnit(delayMin), p, inUnit(delayAvg), p, inUnit(delayMax), opts.unit)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+    "os"
+    "sync"
+)
+
+// An output file that can be reopened at the same path while being written,
+// so logrotate can move it away and have a new one created
+type outputFile struct {
+    mu sync.Mutex
+    path string
+    f *os.File
+}
+
+// Open the output file for appending
+func openOutputFile(path string) (*outputFile, error) {
+    f, err := os.OpenFile(path, os.O_WRONLY | os.O_CREATE | os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+    return &outputFile{path: path, f: f}, nil
+}
+
+func (o *outputFile) Write(b []byte) (int, error) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    return o.f.Write(b)
+}
+
+// Close the file and open the path again, keeping the old file if the path
+// cannot be opened
+func (o *outputFile) Reopen() error {
+    f, err := os.OpenFile(o.path, os.O_WRONLY | os.O_CREATE | os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    o.f.Close()
+    o.f = f
+    return nil
+}
+
+func (o *outputFile) Close() error {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    return o.f.Close()
+}
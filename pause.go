@@ -0,0 +1,54 @@
+package main
+
+import (
+    "sync"
+)
+
+// Whether sending is paused by SIGUSR1, until SIGUSR2 resumes it. Replies
+// to echo requests already sent are still waited for
+type pauseState struct {
+    mu sync.Mutex
+    resumed chan bool
+}
+
+var paused pauseState
+
+// Pause sending, reporting whether it was running
+func (p *pauseState) pause() bool {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.resumed != nil {
+        return false
+    }
+    p.resumed = make(chan bool)
+    return true
+}
+
+// Resume sending, reporting whether it was paused
+func (p *pauseState) resume() bool {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.resumed == nil {
+        return false
+    }
+    close(p.resumed)
+    p.resumed = nil
+    return true
+}
+
+// Wait while sending is paused, reporting false if done closes meanwhile
+func (p *pauseState) wait(done chan bool) bool {
+    p.mu.Lock()
+    resumed := p.resumed
+    p.mu.Unlock()
+    if resumed == nil {
+        return true
+    }
+
+    select {
+    case <-done:
+        return false
+    case <-resumed:
+        return true
+    }
+}
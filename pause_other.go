@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// Pause sending on SIGUSR1 and resume it on SIGUSR2, which this platform
+// does not have
+func notifyPause() {
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/signal"
+    "syscall"
+)
+
+// Pause sending on SIGUSR1 and resume it on SIGUSR2
+func notifyPause() {
+    pauseCh := make(chan os.Signal, 1)
+    signal.Notify(pauseCh, syscall.SIGUSR1, syscall.SIGUSR2)
+    go func() {
+        for sig := range pauseCh {
+            if sig == syscall.SIGUSR1 && paused.pause() {
+                fmt.Fprintln(errOut, "Paused, send SIGUSR2 to resume")
+            } else if sig == syscall.SIGUSR2 && paused.resume() {
+                fmt.Fprintln(errOut, "Resumed")
+            }
+        }
+    }()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "os"
+
+    "golang.org/x/net/ipv4"
+    "golang.org/x/net/ipv6"
+)
+
+// Guess the path MTU to the destination by a binary search over the sizes of
+// echo requests sent with the don't fragment bit set, up to the MTU of the
+// outgoing interface. An echo request too big for the path is refused by the
+// kernel, answered by fragmentation needed or packet too big, or just lost,
+// so a lost one is tried again before it counts as too big
+func pathMTU(ip string, isIPv6 bool, ifaceMTU int) (mtu int, probes int, err error) {
+    headers := ipv4.HeaderLen + 8
+    if isIPv6 {
+        headers = ipv6.HeaderLen + 8
+    }
+
+    opts.dontFragment = true
+    defer func() {
+        opts.dontFragment = false
+    }()
+
+    fits := func(size int) bool {
+        for try := 0; try < 2; try++ {
+            _, err := pingOnce(probes, ip, isIPv6, size, opts.ttl)
+            probes++
+            if !errors.Is(err, os.ErrDeadlineExceeded) {
+                return err == nil
+            }
+        }
+        return false
+    }
+
+    lo, hi := paddingStart(), ifaceMTU - headers
+    if hi > maxDataSize {
+        hi = maxDataSize
+    }
+    if hi < lo || !fits(lo) {
+        err = fmt.Errorf("no reply to the smallest echo request")
+        return
+    }
+
+    // Most paths carry what the interface does
+    if fits(hi) {
+        return hi + headers, probes, nil
+    }
+    hi--
+
+    // lo always fits, and hi is the largest size left that may
+    for lo < hi {
+        mid := (lo + hi + 1) / 2
+        if fits(mid) {
+            lo = mid
+        } else {
+            hi = mid - 1
+        }
+    }
+    return lo + headers, probes, nil
+}
+
+// Print the path MTU guessed before pinging, starting from the MTU of the
+// interface named or addressed by source, or else the one used for the
+// destination
+func printPathMTU(ip string, isIPv6 bool, source string) {
+    ifaceMTU, err := interfaceMTU(source, net.ParseIP(ip))
+    if err != nil {
+        fmt.Fprintln(errOut, "Cannot guess the path MTU:", err)
+        return
+    }
+    mtu, probes, err := pathMTU(ip, isIPv6, ifaceMTU)
+    if err != nil {
+        fmt.Fprintln(errOut, "Cannot guess the path MTU:", err)
+        return
+    }
+    fmt.Fprintf(out, "Path MTU guess: %v bytes (interface %v, %v probes)\n",
+        mtu, ifaceMTU, probes)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+    "context"
+    "strings"
+    "sync"
+)
+
+// Names found by reverse DNS, with "" for addresses that have none or whose
+// lookup failed, so a broken resolver stalls output only once per address
+var reverseNames = struct {
+    sync.Mutex
+    names map[string]string
+}{names: make(map[string]string)}
+
+// Look up the name of an address, giving up after --reverse-dns-timeout
+func reverseName(ip string) string {
+    reverseNames.Lock()
+    defer reverseNames.Unlock()
+    if name, ok := reverseNames.names[ip]; ok {
+        return name
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), opts.reverseTimeout)
+    defer cancel()
+    var name string
+    names, err := resolver.LookupAddr(ctx, ip)
+    if err == nil && len(names) > 0 {
+        name = strings.TrimSuffix(names[0], ".")
+    }
+    reverseNames.names[ip] = name
+    return name
+}
+
+// Format an address with its name if --reverse-dns is given and it has one
+func displayAddr(ip string) string {
+    if !opts.reverseDNS {
+        return ip
+    }
+    if name := reverseName(ip); name != "" {
+        return name + " (" + ip + ")"
+    }
+    return ip
+}
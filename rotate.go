@@ -0,0 +1,67 @@
+package main
+
+import (
+    "fmt"
+    "net"
+)
+
+// Get the addresses of this host in the family of the target to rotate the
+// source through. Loopback addresses only reach a loopback target and
+// link-local ones need a zone, so only the kind that fits the target is kept
+func rotationSources(dst net.IP, isIPv6 bool) (ips []net.IP, err error) {
+    addrs, err := net.InterfaceAddrs()
+    if err != nil {
+        return
+    }
+
+    for _, addr := range addrs {
+        ipNet, ok := addr.(*net.IPNet)
+        if !ok || (ipNet.IP.To4() == nil) != isIPv6 {
+            continue
+        }
+        if dst.IsLoopback() && ipNet.IP.IsLoopback() ||
+            !dst.IsLoopback() && ipNet.IP.IsGlobalUnicast() {
+            ips = append(ips, ipNet.IP)
+        }
+    }
+
+    if len(ips) == 0 {
+        err = fmt.Errorf("no local address to send to %v from", dst)
+    }
+    return
+}
+
+// Record the result of an echo request sent from the given source
+func recordSource(s *statsData, source net.IP, reply echoReply, err error) {
+    if s.bySource == nil {
+        s.bySource = make(map[string]*statsData)
+    }
+    src, ok := s.bySource[source.String()]
+    if !ok {
+        src = &statsData{rtts: make([]float64, 0)}
+        s.bySource[source.String()] = src
+    }
+
+    src.trans++
+    if err == nil {
+        src.recv++
+        src.rtts = append(src.rtts, reply.rtt)
+    }
+}
+
+// Print a line of statistics for each source rotated through, in the order
+// of rotation
+func sourceStats(s *statsData) {
+    p := opts.precision
+    for _, source := range opts.rotateSources {
+        src, ok := s.bySource[source.String()]
+        if !ok {
+            continue
+        }
+        rttMin, rttMax, rttAvg, _ := rttStats(src)
+        fmt.Fprintf(out, "from %v: %v transmitted, %v received, %.1f%% loss, " +
+            "rtt min/avg/max = %.*f/%.*f/%.*f %v\n",
+            source, src.trans, src.recv, lossPercent(src),
+            p, inUnit(rttMin), p, inUnit(rttAvg), p, inUnit(rttMax), opts.unit)
+    }
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+    "bufio"
+    "encoding/hex"
+    "fmt"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+)
+
+const rtfGateway = 0x2
+
+// Find the default gateway of the given family from the kernel routing table
+func defaultGateway(isIPv6 bool) (gw string, err error) {
+    path := "/proc/net/route"
+    if isIPv6 {
+        path = "/proc/net/ipv6_route"
+    }
+
+    f, err := os.Open(path)
+    if err != nil {
+        err = fmt.Errorf("cannot read routing table: %v", err)
+        return
+    }
+    defer f.Close()
+
+    bestMetric := uint64(0)
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        var ip string
+        var metric uint64
+        var ok bool
+        if isIPv6 {
+            ip, metric, ok = parseIPv6Route(scanner.Text())
+        } else {
+            ip, metric, ok = parseIPv4Route(scanner.Text())
+        }
+        if ok && (gw == "" || metric < bestMetric) {
+            gw, bestMetric = ip, metric
+        }
+    }
+
+    if err = scanner.Err(); err != nil {
+        return
+    }
+    if gw == "" {
+        err = fmt.Errorf("no default route")
+    }
+    return
+}
+
+// Parse a line of /proc/net/route, reporting whether it is a default route
+func parseIPv4Route(line string) (gw string, metric uint64, ok bool) {
+    fields := strings.Fields(line)
+    if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+        return
+    }
+
+    flags, err := strconv.ParseUint(fields[3], 16, 32)
+    if err != nil || flags&rtfGateway == 0 {
+        return
+    }
+
+    b, err := hex.DecodeString(fields[2])
+    if err != nil || len(b) != 4 {
+        return
+    }
+    metric, err = strconv.ParseUint(fields[6], 10, 32)
+    if err != nil {
+        return
+    }
+
+    // The gateway is stored in host byte order
+    gw = net.IPv4(b[3], b[2], b[1], b[0]).String()
+    ok = true
+    return
+}
+
+// Parse a line of /proc/net/ipv6_route, reporting whether it is a default route
+func parseIPv6Route(line string) (gw string, metric uint64, ok bool) {
+    fields := strings.Fields(line)
+    if len(fields) < 10 || fields[1] != "00" || fields[9] == "lo" {
+        return
+    }
+
+    flags, err := strconv.ParseUint(fields[8], 16, 32)
+    if err != nil || flags&rtfGateway == 0 {
+        return
+    }
+
+    b, err := hex.DecodeString(fields[4])
+    if err != nil || len(b) != net.IPv6len {
+        return
+    }
+    metric, err = strconv.ParseUint(fields[5], 16, 32)
+    if err != nil {
+        return
+    }
+
+    ip := net.IP(b)
+    gw = ip.String()
+    if ip.IsLinkLocalUnicast() {
+        gw += "%" + fields[9]
+    }
+    ok = true
+    return
+}
+
+// Find the source address the kernel would send to the destination from
+func routeSource(dst net.IP) (local net.IP, err error) {
+    // Connecting a UDP socket sends nothing but picks the source address
+    c, err := net.Dial("udp", net.JoinHostPort(dst.String(), "9"))
+    if err != nil {
+        return
+    }
+    defer c.Close()
+    return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// Find the MTU of the interface named or addressed by source, or else of the
+// interface the kernel would send to the destination from
+func interfaceMTU(source string, dst net.IP) (mtu int, err error) {
+    if source != "" && net.ParseIP(source) == nil {
+        var iface *net.Interface
+        iface, err = net.InterfaceByName(source)
+        if err != nil {
+            return
+        }
+        return iface.MTU, nil
+    }
+
+    local := net.ParseIP(source)
+    if local == nil {
+        local, err = routeSource(dst)
+        if err != nil {
+            return
+        }
+    }
+
+    ifaces, err := net.Interfaces()
+    if err != nil {
+        return
+    }
+    for _, iface := range ifaces {
+        addrs, err := iface.Addrs()
+        if err != nil {
+            continue
+        }
+        for _, addr := range addrs {
+            if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(local) {
+                return iface.MTU, nil
+            }
+        }
+    }
+    err = fmt.Errorf("no interface has address %v", local)
+    return
+}
+
+// Find the interface on whose subnet the given gateway lies, along with the
+// address of the interface to send from
+func gatewayInterface(gw net.IP) (iface net.Interface, source net.IP, err error) {
+    ifaces, err := net.Interfaces()
+    if err != nil {
+        return
+    }
+    for _, iface = range ifaces {
+        addrs, err := iface.Addrs()
+        if err != nil {
+            continue
+        }
+        for _, addr := range addrs {
+            ipNet, ok := addr.(*net.IPNet)
+            if ok && (ipNet.IP.To4() == nil) == (gw.To4() == nil) && ipNet.Contains(gw) {
+                return iface, ipNet.IP, nil
+            }
+        }
+    }
+    err = fmt.Errorf("no route to gateway %v, it is on no local subnet", gw)
+    return
+}
+
+// Get the name of the interface with the given index, or the index itself
+// if the interface is gone
+func interfaceName(index int) string {
+    iface, err := net.InterfaceByIndex(index)
+    if err != nil {
+        return "if" + strconv.Itoa(index)
+    }
+    return iface.Name
+}
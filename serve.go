@@ -0,0 +1,136 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "os"
+    "strconv"
+    "sync/atomic"
+    "time"
+)
+
+// The most echo requests a single HTTP request may ask for
+const maxServeCount = 10
+
+// The most HTTP requests pinging at once, the rest being turned away
+const maxServeRequests = 4
+
+// How long to wait for a host given over HTTP to resolve
+const serveResolveTimeout = 5 * time.Second
+
+// Sequence numbers are shared by all HTTP requests, as replies are matched by
+// sequence and concurrent requests may ping the same host
+var serveSeq int32
+
+type serveResult struct {
+    Seq int `json:"seq"`
+    From string `json:"from,omitempty"`
+    TTL int `json:"ttl,omitempty"`
+    RTT float64 `json:"rtt,omitempty"`
+    Error string `json:"error,omitempty"`
+}
+
+type serveResponse struct {
+    summaryJSON
+    Results []serveResult `json:"results"`
+}
+
+type serveError struct {
+    Error string `json:"error"`
+}
+
+// Write a value as the JSON body of a response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+// Serve GET /ping?host=X&count=N, pinging the host with echo requests of
+// dataSize bytes and answering with the results and summary as JSON
+func serve(addr string, dataSize int) error {
+    busy := make(chan struct{}, maxServeRequests)
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            writeJSON(w, http.StatusMethodNotAllowed, serveError{"only GET is allowed"})
+            return
+        }
+
+        host := r.URL.Query().Get("host")
+        if host == "" || len(host) > 253 {
+            writeJSON(w, http.StatusBadRequest, serveError{"host is required"})
+            return
+        }
+        count := 1
+        if arg := r.URL.Query().Get("count"); arg != "" {
+            var err error
+            count, err = strconv.Atoi(arg)
+            if err != nil || count < 1 || count > maxServeCount {
+                writeJSON(w, http.StatusBadRequest, serveError{
+                    "count must be a number from 1 to " + strconv.Itoa(maxServeCount)})
+                return
+            }
+        }
+
+        select {
+        case busy <- struct{}{}:
+            defer func() { <-busy }()
+        default:
+            writeJSON(w, http.StatusServiceUnavailable, serveError{"too many requests"})
+            return
+        }
+
+        ip, _, isIPv6, err := resolve(host, serveResolveTimeout)
+        var famErr familyError
+        if errors.As(err, &famErr) {
+            writeJSON(w, http.StatusBadRequest, serveError{err.Error()})
+            return
+        } else if err != nil {
+            writeJSON(w, http.StatusBadGateway, serveError{"cannot resolve host: " + err.Error()})
+            return
+        }
+
+        s := statsData{rtts: make([]float64, 0), start: time.Now()}
+        results := make([]serveResult, 0, count)
+        seq := int(atomic.AddInt32(&serveSeq, int32(count))) - count
+        for i := 0; i < count; i++ {
+            if i > 0 {
+                select {
+                case <-r.Context().Done():
+                    return
+                case <-time.After(opts.interval):
+                }
+            }
+
+            reply, err := pingOnce(seq + i, ip.String(), isIPv6, dataSize, opts.ttl)
+            s.trans++
+            result := serveResult{Seq: i}
+            if reply.from != nil {
+                result.From = reply.from.String()
+            }
+            if errors.Is(err, os.ErrDeadlineExceeded) {
+                result.Error = "timeout"
+            } else if err != nil {
+                result.Error = err.Error()
+            } else {
+                s.recv++
+                s.rtts = append(s.rtts, reply.rtt)
+                result.TTL = reply.ttl
+                result.RTT = reply.rtt
+            }
+            results = append(results, result)
+        }
+
+        writeJSON(w, http.StatusOK, serveResponse{jsonSummary(&s, host, ip.String()), results})
+    })
+
+    server := http.Server{
+        Addr: addr,
+        Handler: mux,
+        ReadHeaderTimeout: 10 * time.Second,
+    }
+    return server.ListenAndServe()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The largest payload an echo request can carry in a single datagram
+const maxDataSize = 65507
+
+// Parse a size sweep given as start:end:step
+func parseSizeSweep(arg string) (start int, end int, step int, err error) {
+    parts := strings.Split(arg, ":")
+    if len(parts) != 3 {
+        err = fmt.Errorf("size sweep must be given as start:end:step")
+        return
+    }
+
+    values := make([]int, 3)
+    for i, part := range parts {
+        values[i], err = strconv.Atoi(part)
+        if err != nil {
+            err = fmt.Errorf("invalid size sweep value %q", part)
+            return
+        }
+    }
+    start, end, step = values[0], values[1], values[2]
+
+    if start < paddingStart() {
+        err = fmt.Errorf("size sweep must start at %v bytes or more", paddingStart())
+    } else if end < start || end > maxDataSize {
+        err = fmt.Errorf("size sweep must end between %v and %v bytes", start, maxDataSize)
+    } else if step <= 0 {
+        err = fmt.Errorf("size sweep step must be a positive number")
+    }
+    return
+}
+
+type sweepResult struct {
+    size int
+    rtt float64
+    lost bool
+}
+
+// Ping once with each payload size in the sweep and print the round-trip
+// times by size
+func sizeSweep(ip string, isIPv6 bool, start int, end int, step int, done chan bool) {
+    results := make([]sweepResult, 0)
+
+loop:
+    for size, i := start, 0; size <= end; size, i = size + step, i + 1 {
+        reply, err := pingOnce(i, ip, isIPv6, size, opts.ttl)
+        results = append(results, sweepResult{size, reply.rtt, err != nil})
+
+        if err != nil {
+            fmt.Fprintf(errOut, "Request timeout for %v data bytes\n", size)
+        } else {
+            fmt.Fprintf(out, "Packet from %v: %v data bytes time=%.*f %v\n",
+                displayAddr(ip), size, opts.precision, inUnit(reply.rtt), opts.unit)
+        }
+
+        if size + step > end {
+            break
+        }
+        select {
+        case <-done:
+            break loop
+        case <-time.After(opts.interval):
+        }
+    }
+
+    fmt.Fprintln(out, "\n--- Size sweep ---")
+    fmt.Fprintf(out, "%8v  %v\n", "size", "rtt")
+    for _, r := range results {
+        if r.lost {
+            fmt.Fprintf(out, "%8v  %v\n", r.size, "timeout")
+        } else {
+            fmt.Fprintf(out, "%8v  %.*f %v\n", r.size, opts.precision, inUnit(r.rtt), opts.unit)
+        }
+    }
+}
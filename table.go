@@ -0,0 +1,30 @@
+package main
+
+import (
+    "fmt"
+)
+
+// The width of the address column, enough for any address of the family
+func addressWidth(isIPv6 bool) int {
+    if isIPv6 {
+        return len("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")
+    }
+    return len("255.255.255.255")
+}
+
+// Print the header of the reply table
+func tableHeader(isIPv6 bool) {
+    param := "TTL"
+    if isIPv6 {
+        param = "HLIM"
+    }
+    fmt.Fprintf(out, "%8v  %-*v  %4v  %5v  %12v\n", "SEQ", addressWidth(isIPv6), "FROM",
+        param, "BYTES", "RTT (" + opts.unit + ")")
+}
+
+// Print a reply as a row of the table
+func tableRow(seq int, isIPv6 bool, from string, ttl int, size int, rtt float64,
+    note string) {
+    fmt.Fprintf(out, "%8v  %-*v  %4v  %5v  %12.*f%v\n", formatSeq(seq), addressWidth(isIPv6),
+        from, ttl, size, opts.precision, inUnit(rtt), note)
+}
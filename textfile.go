@@ -0,0 +1,77 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// The round-trip time quantiles written to the textfile
+var textfileQuantiles = []float64{0.5, 0.9, 0.99}
+
+// Get the quantile of sorted round-trip times by the nearest rank
+func quantile(sorted []float64, q float64) float64 {
+    if len(sorted) == 0 {
+        return 0
+    }
+    i := int(q * float64(len(sorted)) + 0.5) - 1
+    if i < 0 {
+        i = 0
+    } else if i >= len(sorted) {
+        i = len(sorted) - 1
+    }
+    return sorted[i]
+}
+
+// Write the statistics in the Prometheus text format for the textfile
+// collector of node_exporter, replacing the file at once so that a scrape
+// never reads it half written
+func writeTextfile(path string, s *statsData, host string, ip string) error {
+    labels := fmt.Sprintf("host=%q,ip=%q", host, ip)
+    sorted := append([]float64(nil), s.rtts...)
+    sort.Float64s(sorted)
+    sum := 0.0
+    for _, rtt := range sorted {
+        sum += rtt
+    }
+
+    var b strings.Builder
+    fmt.Fprintln(&b, "# HELP ping_packets_transmitted Echo requests sent.")
+    fmt.Fprintln(&b, "# TYPE ping_packets_transmitted gauge")
+    fmt.Fprintf(&b, "ping_packets_transmitted{%v} %v\n", labels, s.trans)
+    fmt.Fprintln(&b, "# HELP ping_packets_received Echo replies received.")
+    fmt.Fprintln(&b, "# TYPE ping_packets_received gauge")
+    fmt.Fprintf(&b, "ping_packets_received{%v} %v\n", labels, s.recv)
+    fmt.Fprintln(&b, "# HELP ping_loss_ratio Fraction of echo requests without a reply.")
+    fmt.Fprintln(&b, "# TYPE ping_loss_ratio gauge")
+    fmt.Fprintf(&b, "ping_loss_ratio{%v} %v\n", labels, lossPercent(s) / 100)
+    fmt.Fprintln(&b, "# HELP ping_rtt_milliseconds Round-trip times of echo replies.")
+    fmt.Fprintln(&b, "# TYPE ping_rtt_milliseconds summary")
+    for _, q := range textfileQuantiles {
+        fmt.Fprintf(&b, "ping_rtt_milliseconds{%v,quantile=\"%v\"} %v\n",
+            labels, q, quantile(sorted, q))
+    }
+    fmt.Fprintf(&b, "ping_rtt_milliseconds_sum{%v} %v\n", labels, sum)
+    fmt.Fprintf(&b, "ping_rtt_milliseconds_count{%v} %v\n", labels, len(sorted))
+
+    // A rename within the directory is atomic
+    tmp, err := os.CreateTemp(filepath.Dir(path), ".ping-textfile-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err = tmp.WriteString(b.String()); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err = tmp.Chmod(0644); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err = tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// DSCP code points by class name (RFC 2474, 2597, 3246, 5865, 8622)
+var dscpClasses = map[string]int{
+    "cs0": 0, "cs1": 8, "cs2": 16, "cs3": 24,
+    "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+    "af11": 10, "af12": 12, "af13": 14,
+    "af21": 18, "af22": 20, "af23": 22,
+    "af31": 26, "af32": 28, "af33": 30,
+    "af41": 34, "af42": 36, "af43": 38,
+    "ef": 46, "va": 44, "le": 1,
+}
+
+// ECN code points by name (RFC 3168)
+var ecnCodepoints = map[string]int{
+    "ect1": 1, "ect0": 2, "ce": 3,
+}
+
+// Parse the TOS byte from a comma-separated list of raw values, a DSCP class
+// name and an ECN code point name, e.g. "af41", "ef,ect0" or "0xb8"
+func parseTOS(arg string) (tos int, err error) {
+    hasClass := false
+    for _, token := range strings.Split(arg, ",") {
+        token = strings.ToLower(strings.TrimSpace(token))
+
+        if dscp, ok := dscpClasses[token]; ok {
+            if hasClass {
+                err = fmt.Errorf("more than one DSCP class in %q", arg)
+                return
+            }
+            hasClass = true
+            tos |= dscp << 2
+        } else if ecn, ok := ecnCodepoints[token]; ok {
+            tos |= ecn
+        } else {
+            var value uint64
+            value, err = strconv.ParseUint(token, 0, 8)
+            if err != nil {
+                err = fmt.Errorf("invalid TOS value or class %q", token)
+                return
+            }
+            tos |= int(value)
+        }
+    }
+    return
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "fmt"
+    "net"
+    "strings"
+    "time"
+)
+
+// Trace the route to the destination by sending echo requests with the TTL
+// raised from 1, printing the router which reports the TTL exceeded at each
+// hop until the destination itself replies
+func trace(ip string, isIPv6 bool, dataSize int, maxHops int, probes int,
+    done chan bool) {
+    seq := 0
+    for ttl := 1; ttl <= maxHops; ttl++ {
+        var line strings.Builder
+        var last net.IP
+        reached := false
+
+        fmt.Fprintf(&line, "%2v ", ttl)
+        for p := 0; p < probes; p++ {
+            reply, err := pingOnce(seq, ip, isIPv6, dataSize, ttl)
+            seq++
+
+            if err != nil && err != errTimeExceeded {
+                line.WriteString(" *")
+            } else {
+                if !reply.from.Equal(last) {
+                    fmt.Fprintf(&line, " %v", displayAddr(reply.from.String()))
+                    last = reply.from
+                }
+                fmt.Fprintf(&line, "  %.*f %v", opts.precision, inUnit(reply.rtt), opts.unit)
+                reached = reached || err == nil
+            }
+
+            select {
+            case <-done:
+                fmt.Fprintln(out, line.String())
+                return
+            default:
+            }
+        }
+        fmt.Fprintln(out, line.String())
+
+        if reached {
+            return
+        }
+
+        select {
+        case <-done:
+            return
+        case <-time.After(opts.interval):
+        }
+    }
+}
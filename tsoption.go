@@ -0,0 +1,96 @@
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+    "net"
+)
+
+const (
+    ipOptTimestamp = 0x44
+    ipOptEnd = 0
+    ipOptNop = 1
+
+    // Record the address of each router along with its timestamp
+    tsFlagAddress = 1
+    tsEntryLen = 8
+    tsSlots = 4
+)
+
+// Build an empty IPv4 timestamp option asking each router on the path to add
+// its address and timestamp
+func timestampOption() []byte {
+    length := 4 + tsSlots * tsEntryLen
+    option := make([]byte, length)
+    option[0] = ipOptTimestamp
+    option[1] = byte(length)
+    option[2] = 5
+    option[3] = tsFlagAddress
+    return option
+}
+
+// An address and timestamp recorded in the timestamp option
+type tsEntry struct {
+    addr net.IP
+    // Milliseconds since midnight UT
+    ms uint32
+}
+
+// Parse the entries of the timestamp option among IPv4 header options, and
+// the count of routers which had no room left to record
+func parseTimestamps(options []byte) (entries []tsEntry, overflow int) {
+    for i := 0; i < len(options); {
+        kind := options[i]
+        if kind == ipOptEnd {
+            break
+        }
+        if kind == ipOptNop {
+            i++
+            continue
+        }
+        if i + 1 >= len(options) || options[i + 1] < 2 || i + int(options[i + 1]) > len(options) {
+            break
+        }
+
+        option := options[i:i + int(options[i + 1])]
+        i += len(option)
+        if kind != ipOptTimestamp || len(option) < 4 || option[3] & 0x0f != tsFlagAddress {
+            continue
+        }
+
+        overflow = int(option[3] >> 4)
+        // The pointer is one-based and points past the last entry recorded
+        end := int(option[2]) - 1
+        if end > len(option) {
+            end = len(option)
+        }
+        for j := 4; j + tsEntryLen <= end; j += tsEntryLen {
+            entries = append(entries, tsEntry{
+                addr: net.IP(append([]byte(nil), option[j:j + 4]...)),
+                ms: binary.BigEndian.Uint32(option[j + 4:j + 8]),
+            })
+        }
+    }
+    return
+}
+
+// Print the timestamps recorded by the routers on the path, the first one
+// absolute and the rest relative to the previous one
+func printTimestamps(options []byte) {
+    entries, overflow := parseTimestamps(options)
+    if len(entries) == 0 {
+        fmt.Fprintln(out, "TS: no timestamps recorded")
+    }
+
+    for i, e := range entries {
+        if i == 0 {
+            fmt.Fprintf(out, "TS: %v\t%v absolute\n", e.addr, e.ms)
+        } else {
+            delta := int64(e.ms) - int64(entries[i - 1].ms)
+            fmt.Fprintf(out, "    %v\t%v ms\n", e.addr, delta)
+        }
+    }
+    if overflow > 0 {
+        fmt.Fprintf(out, "    %v routers had no room to record\n", overflow)
+    }
+}
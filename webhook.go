@@ -0,0 +1,44 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "time"
+)
+
+// How long to wait for the webhook to accept the summary
+const webhookTimeout = 5 * time.Second
+
+// Check that a webhook is given as an http or https URL
+func checkWebhook(raw string) error {
+    u, err := url.Parse(raw)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return fmt.Errorf("webhook must be an http or https URL")
+    }
+    return nil
+}
+
+// POST the JSON summary to the webhook, reporting whether it was delivered
+func postSummary(webhook string, s *statsData, host string, ip string) {
+    data, err := json.Marshal(jsonSummary(s, host, ip))
+    if err != nil {
+        fmt.Fprintln(errOut, "Error: Failed to marshal summary", err)
+        return
+    }
+
+    client := http.Client{Timeout: webhookTimeout}
+    resp, err := client.Post(webhook, "application/json", bytes.NewReader(data))
+    if err != nil {
+        fmt.Fprintln(errOut, "Webhook delivery failed:", err)
+        return
+    }
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        fmt.Fprintln(errOut, "Webhook delivery failed:", resp.Status)
+        return
+    }
+    fmt.Fprintln(errOut, "Webhook delivered:", resp.Status)
+}